@@ -0,0 +1,278 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+)
+
+// NewEXP3 constructs an EXP3 strategy (Auer et al., 2002). EXP3 makes no
+// stochastic assumptions on rewards, which must be in [0, 1]. γ is the share
+// of uniform exploration.
+func NewEXP3(arms int, γ float64) (Strategy, error) {
+	if !(γ > 0 && γ <= 1) {
+		return &exp3{}, fmt.Errorf("γ not in (0, 1]")
+	}
+
+	return &exp3{
+		Counters:   NewCounters(arms),
+		gamma:      γ,
+		logWeights: make([]float64, arms),
+	}, nil
+}
+
+// exp3 keeps exponential weights of importance weighted rewards per arm.
+// Arms are selected proportionally to their weights, mixed with uniform
+// exploration.
+type exp3 struct {
+	Counters
+	gamma      float64   // share of uniform exploration
+	logWeights []float64 // log of exponential weight per arm
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (e *exp3) SelectArm() int {
+	arm := draw(e.rand.Float64(), e.Probabilities())
+	e.counts[arm]++
+	return arm + 1
+}
+
+// propensityUpdater strategies weight rewards by the inverse probability with
+// which the arm was selected. Update estimates it with the current
+// probabilities, which have moved since the decision if other rewards came in
+// between.
+type propensityUpdater interface {
+	updateWithPropensity(arm int, reward, propensity float64)
+}
+
+// Update the weight of the 1 indexed arm with its importance weighted reward,
+// given the current probability of the arm.
+func (e *exp3) Update(arm int, reward float64) {
+	e.Counters.Update(arm, reward)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.gain(arm-1, reward, e.Probabilities()[arm-1])
+}
+
+// updateWithPropensity updates the weight of the 1 indexed arm with its
+// reward, weighted by the propensity logged when it was selected.
+func (e *exp3) updateWithPropensity(arm int, reward, propensity float64) {
+	e.Counters.Update(arm, reward)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.gain(arm-1, reward, propensity)
+}
+
+// gain adds the reward of the 0 indexed arm, selected with probability p, to
+// its weight. Arms are selected with probability at least γ/K, below which p
+// is clamped. Callers hold the lock.
+func (e *exp3) gain(arm int, reward, p float64) {
+	k := float64(e.arms)
+	e.logWeights[arm] += e.gamma * (reward / math.Max(p, e.gamma/k)) / k
+}
+
+// rewardRange returns [0, 1], the range of rewards assumed by EXP3.
+func (e *exp3) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (e *exp3) Probabilities() []float64 {
+	return exponentialWeights(e.logWeights, e.gamma)
+}
+
+// Init the strategy from a snapshot. Importance weighted reward sums are
+// estimated from mean rewards and the total number of pulls.
+func (e *exp3) Init(c *Counters) error {
+	if err := e.Counters.Init(c); err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.logWeights = estimatedGains(&e.Counters, e.gamma/float64(e.arms))
+	return nil
+}
+
+// addArm appends an arm. It starts with the weight of the best arm, so that it
+// is explored.
+func (e *exp3) addArm(count int, value float64) {
+	e.Counters.addArm(count, value)
+
+	e.Lock()
+	defer e.Unlock()
+
+	max, _ := bmath.Max(e.logWeights)
+	e.logWeights = append(e.logWeights, max)
+}
+
+// removeArm removes the 1 indexed arm.
+func (e *exp3) removeArm(arm int) {
+	e.Counters.removeArm(arm)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.logWeights = removeFloat(e.logWeights, arm-1)
+}
+
+// Reset the strategy to initial state.
+func (e *exp3) Reset() {
+	e.Counters.Reset()
+	e.logWeights = make([]float64, e.arms)
+}
+
+// String returns information on this strategy
+func (e *exp3) String() string {
+	return fmt.Sprintf("EXP3(gamma=%.2f)", e.gamma)
+}
+
+// NewEXP3P constructs an EXP3.P strategy (Auer et al., 2002). EXP3.P adds a
+// confidence bonus to EXP3, so that regret bounds hold with high probability
+// rather than in expectation. α controls the bonus, `horizon` is the expected
+// number of trials.
+func NewEXP3P(arms int, γ, α float64, horizon int) (Strategy, error) {
+	if !(γ > 0 && γ <= 1) {
+		return &exp3P{}, fmt.Errorf("γ not in (0, 1]")
+	}
+
+	if !(α >= 0) {
+		return &exp3P{}, fmt.Errorf("α not in [0, ∞)")
+	}
+
+	if horizon < 1 {
+		return &exp3P{}, fmt.Errorf("horizon not in [1, ∞)")
+	}
+
+	return &exp3P{
+		exp3: exp3{
+			Counters:   NewCounters(arms),
+			gamma:      γ,
+			logWeights: make([]float64, arms),
+		},
+		alpha:   α,
+		horizon: horizon,
+	}, nil
+}
+
+// exp3P is EXP3 with an upper confidence bonus on the estimated rewards.
+type exp3P struct {
+	exp3
+	alpha   float64 // confidence bonus
+	horizon int     // expected number of trials
+}
+
+// Update the weights of all arms. The 1 indexed arm receives its importance
+// weighted reward given its current probability, all arms receive their
+// confidence bonus.
+func (e *exp3P) Update(arm int, reward float64) {
+	e.Counters.Update(arm, reward)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.gains(arm-1, reward, e.Probabilities()[arm-1])
+}
+
+// updateWithPropensity is Update with the reward weighted by the propensity
+// logged when the arm was selected.
+func (e *exp3P) updateWithPropensity(arm int, reward, propensity float64) {
+	e.Counters.Update(arm, reward)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.gains(arm-1, reward, propensity)
+}
+
+// gains adds the reward of the 0 indexed arm, selected with probability
+// `propensity`, and the confidence bonus of each arm to the weights. The
+// propensity is clamped to at least γ/K, like in exp3. Callers hold the lock.
+func (e *exp3P) gains(arm int, reward, propensity float64) {
+	k := float64(e.arms)
+	ps := e.Probabilities()
+	for i, p := range ps {
+		gain := e.alpha / (p * math.Sqrt(k*float64(e.horizon)))
+		if i == arm {
+			gain += reward / math.Max(propensity, e.gamma/k)
+		}
+
+		e.logWeights[i] += e.gamma / (3 * k) * gain
+	}
+}
+
+// Init the strategy from a snapshot. Importance weighted reward sums are
+// estimated from mean rewards and the total number of pulls.
+func (e *exp3P) Init(c *Counters) error {
+	if err := e.Counters.Init(c); err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.logWeights = estimatedGains(&e.Counters, e.gamma/(3*float64(e.arms)))
+	return nil
+}
+
+// String returns information on this strategy
+func (e *exp3P) String() string {
+	return fmt.Sprintf("EXP3.P(gamma=%.2f, alpha=%.2f, horizon=%d)", e.gamma, e.alpha, e.horizon)
+}
+
+// exponentialWeights mixes normalized exponential weights with γ uniform
+// exploration.
+func exponentialWeights(logWeights []float64, γ float64) []float64 {
+	max, _ := bmath.Max(logWeights)
+
+	normalizer := 0.0
+	for _, logWeight := range logWeights {
+		normalizer += math.Exp(logWeight - max)
+	}
+
+	k := float64(len(logWeights))
+	ps := make([]float64, len(logWeights))
+	for i, logWeight := range logWeights {
+		ps[i] = (1-γ)*math.Exp(logWeight-max)/normalizer + γ/k
+	}
+
+	return ps
+}
+
+// estimatedGains returns log weights from counters. Importance weighted
+// reward sums are unbiased estimates of the reward the arm would have gained
+// if it had been pulled every time.
+func estimatedGains(c *Counters, η float64) []float64 {
+	total := 0
+	for _, count := range c.counts {
+		total += count
+	}
+
+	logWeights := make([]float64, c.arms)
+	for i, value := range c.values {
+		logWeights[i] = η * float64(total) * value
+	}
+
+	return logWeights
+}
+
+// draw returns the 0 indexed arm for z ∈ [0, 1) given arm probabilities.
+func draw(z float64, ps []float64) int {
+	cumulativeProb := 0.0
+	for i, p := range ps {
+		cumulativeProb += p
+		if cumulativeProb > z {
+			return i
+		}
+	}
+
+	return len(ps) - 1
+}
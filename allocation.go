@@ -0,0 +1,72 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"time"
+)
+
+// RampStep sets the allocation of an experiment from time `At` on.
+type RampStep struct {
+	At         time.Time `json:"at"`
+	Allocation float64   `json:"allocation"`
+}
+
+// enrolled returns true if the uid is within the allocation of the
+// experiment. Uids are hashed onto [0, 1) independently of their variation,
+// so that enrolled users stay enrolled as the allocation ramps up. Partial
+// allocations require a uid: anonymous requests are not enrolled, since they
+// would be enrolled anew on every request.
+func (e *Experiment) enrolled(uid string, now time.Time) bool {
+	allocation := e.allocationAt(now)
+	if allocation >= 1 {
+		return true
+	}
+
+	if uid == "" {
+		return false
+	}
+
+	return stickyHash(e.Salt+":allocation", uid) < allocation
+}
+
+// allocationAt returns the fraction of users enrolled at time `now`, given
+// the ramp schedule. Experiments without allocation enroll all users.
+func (e *Experiment) allocationAt(now time.Time) float64 {
+	allocation := e.Allocation
+	for _, step := range e.Ramp {
+		if now.Before(step.At) {
+			break
+		}
+
+		allocation = step.Allocation
+	}
+
+	if allocation == 0 {
+		return 1
+	}
+
+	return allocation
+}
+
+// checkAllocation returns an error if an allocation is not in (0, 1], or if
+// the ramp is not in chronological order.
+func checkAllocation(allocation float64, ramp []RampStep) error {
+	if !(allocation > 0 && allocation <= 1) {
+		return fmt.Errorf("allocation %.2f not in (0, 1]", allocation)
+	}
+
+	for i, step := range ramp {
+		if !(step.Allocation > 0 && step.Allocation <= 1) {
+			return fmt.Errorf("ramp allocation %.2f not in (0, 1]", step.Allocation)
+		}
+
+		if i > 0 && !ramp[i-1].At.Before(step.At) {
+			return fmt.Errorf("ramp is not in chronological order at %s", step.At)
+		}
+	}
+
+	return nil
+}
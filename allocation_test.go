@@ -0,0 +1,85 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocation(t *testing.T) {
+	config := `[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"preferred": 2,
+		"allocation": %s,
+		"ramp": [
+			{"at": "2013-08-22T00:00:00Z", "allocation": 0.5},
+			{"at": "2113-08-22T00:00:00Z", "allocation": 1}
+		],
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`
+
+	e, err := NewExperiment(stringOpener(fmt.Sprintf(config, "0.05")), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	if got := e.allocationAt(time.Now()); got != 0.5 {
+		t.Fatalf("expected ramped allocation of 0.5, got %.2f", got)
+	}
+
+	early := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	enrolled := 0
+	for i := 0; i < 1000; i++ {
+		uid := fmt.Sprintf("%d", i)
+		s, err := e.SelectRequest(Request{UID: uid})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if s.NotEnrolled {
+			if s.Ordinal != 2 {
+				t.Fatalf("expected preferred variation, got %d", s.Ordinal)
+			}
+
+			if line := SelectionLine(e, s); !strings.Contains(line, "BanditNotEnrolled") {
+				t.Fatalf("expected not enrolled line, got %s", line)
+			}
+
+			if e.enrolled(uid, early) {
+				t.Fatalf("uid %s was enrolled before the ramp", uid)
+			}
+
+			continue
+		}
+
+		enrolled++
+	}
+
+	if enrolled < 400 || enrolled > 600 {
+		t.Fatalf("expected about half of the users to be enrolled, got %d", enrolled)
+	}
+
+	for i := 0; i < 100; i++ {
+		s, err := e.SelectRequest(Request{})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if !s.NotEnrolled || s.Ordinal != 2 {
+			t.Fatalf("expected anonymous request not to be enrolled, got %v", s)
+		}
+	}
+
+	if _, err := NewExperiment(stringOpener(fmt.Sprintf(config, "1.5")), "shape"); err == nil {
+		t.Fatalf("expected allocation outside (0, 1] to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"time"
+)
+
+// analysisDraws is the number of posterior samples used to analyse arms.
+const analysisDraws = 10000
+
+// ArmAnalysis answers which arm is winning and how sure we are, given
+// posteriors over the mean rewards of arms.
+type ArmAnalysis struct {
+	Pulls        int     `json:"pulls"`
+	Mean         float64 `json:"mean"`
+	PBest        float64 `json:"p-best"`        // probability that the arm is the best arm
+	ExpectedLoss float64 `json:"expected-loss"` // expected mean reward lost by choosing the arm
+}
+
+// Analyze returns the analysis of each arm, given counters of a strategy or
+// of a snapshot, with Beta posteriors over Bernoulli mean rewards and a
+// uniform prior. Counters without pulls result in uniform posteriors.
+func Analyze(c *Counters) []ArmAnalysis {
+	betaRand := bmath.NewBetaRand(time.Now().UnixNano())
+	return analyze(c, func(arm int) float64 {
+		n := float64(c.counts[arm])
+		si := c.values[arm] * n
+		return betaRand.NextBeta(si+1, n-si+1)
+	})
+}
+
+// analyze returns the analysis of each arm of counters c, given a sampler of
+// the posterior mean reward of the 0 indexed arm.
+func analyze(c *Counters, sample func(arm int) float64) []ArmAnalysis {
+	analysis := make([]ArmAnalysis, c.arms)
+	for i := range analysis {
+		analysis[i].Pulls = c.counts[i]
+		analysis[i].Mean = c.values[i]
+	}
+
+	thetas := make([]float64, c.arms)
+	for draw := 0; draw < analysisDraws; draw++ {
+		for i := range thetas {
+			thetas[i] = sample(i)
+		}
+
+		max, imax := bmath.Max(thetas)
+		for _, i := range imax {
+			analysis[i].PBest += 1 / float64(len(imax)*analysisDraws)
+		}
+
+		for i, theta := range thetas {
+			analysis[i].ExpectedLoss += (max - theta) / analysisDraws
+		}
+	}
+
+	return analysis
+}
+
+// Snapshot returns a copy of the counters.
+func (c *Counters) Snapshot() *Counters {
+	c.Lock()
+	defer c.Unlock()
+
+	return &Counters{
+		arms:    c.arms,
+		counts:  append([]int{}, c.counts...),
+		squares: append([]float64{}, c.squares...),
+		values:  append([]float64{}, c.values...),
+		tags:    c.tags,
+	}
+}
+
+// snapshotter strategies return copies of their counters.
+type snapshotter interface {
+	Snapshot() *Counters
+}
+
+// Analyze returns the analysis of each variation, in order of ordinals.
+func (e *Experiment) Analyze() ([]ArmAnalysis, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	s, ok := e.Strategy.(snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot analyze %s", e.Name, e.Strategy)
+	}
+
+	return e.analyze(s.Snapshot())
+}
+
+// analyze returns the analysis of counters c of the experiment's strategy.
+// Thompson sampling is analyzed with the posteriors of its reward model.
+// Other strategies are analyzed as Bernoulli, unless they assume rewards
+// outside [0, 1].
+func (e *Experiment) analyze(c *Counters) ([]ArmAnalysis, error) {
+	s := e.Strategy
+	if d, ok := s.(*delayedStrategy); ok {
+		s = d.strategy
+	}
+
+	if t, ok := s.(*thompson); ok {
+		posterior, err := NewThompsonModel(c.arms, t.alpha, t.model)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := posterior.Init(c); err != nil {
+			return nil, fmt.Errorf("%s: cannot analyze: %s", e.Name, err.Error())
+		}
+
+		return analyze(c, posterior.(*thompson).sample), nil
+	}
+
+	if b, ok := s.(bounded); ok {
+		if min, max := b.rewardRange(); min != 0 || max != 1 {
+			return nil, fmt.Errorf("%s: cannot analyze rewards in [%g, %g] of %s", e.Name, min, max, e.Strategy)
+		}
+	}
+
+	return Analyze(c), nil
+}
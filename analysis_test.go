@@ -0,0 +1,68 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	c, err := ParseSnapshot(strings.NewReader("3	a:1	1000	0.10	a:2	1000	0.15	a:3	500	0.10"))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	analysis := Analyze(c)
+
+	sum := 0.0
+	for _, arm := range analysis {
+		sum += arm.PBest
+	}
+
+	if math.Abs(sum-1) > 1e-9 {
+		t.Fatalf("p-best sums to %f", sum)
+	}
+
+	if got := analysis[1].PBest; got < 0.9 {
+		t.Fatalf("expected arm 2 to be best with p > 0.9, got %f", got)
+	}
+
+	if got := analysis[1].ExpectedLoss; got > analysis[0].ExpectedLoss {
+		t.Fatalf("expected arm 2 to have the lowest expected loss, got %f", got)
+	}
+
+	if got := analysis[2].Pulls; got != 500 {
+		t.Fatalf("expected 500 pulls of arm 3, got %d", got)
+	}
+}
+
+func TestAnalyzeGaussian(t *testing.T) {
+	strategy, err := NewThompsonModel(2, 1, Gaussian)
+	if err != nil {
+		t.Fatalf("could not make strategy: %s", err.Error())
+	}
+
+	e := Experiment{Name: "shape", Strategy: strategy}
+	for i := 0; i < 100; i++ {
+		for arm, reward := range []float64{4, 6, 19, 21} {
+			strategy.(puller).pull(arm/2 + 1)
+			strategy.Update(arm/2+1, reward)
+		}
+	}
+
+	analysis, err := e.Analyze()
+	if err != nil {
+		t.Fatalf("could not analyze: %s", err.Error())
+	}
+
+	if got := analysis[1].PBest; got < 0.99 {
+		t.Fatalf("expected arm 2 to be best, got p-best %f", got)
+	}
+
+	if got := analysis[0].ExpectedLoss; math.Abs(got-15) > 1 {
+		t.Fatalf("expected loss of about 15 for arm 1, got %f", got)
+	}
+}
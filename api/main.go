@@ -5,17 +5,27 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/bmizerany/pat"
 	"github.com/purzelrakete/bandit"
 	bhttp "github.com/purzelrakete/bandit/http"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
 	apiExperiments = flag.String("experiments", "experiments.json", "local file or http endpoint")
 	apiBind        = flag.String("port", ":8080", "interface / port to bind to")
 	apiPinTTL      = flag.Duration("pin-ttl", 0, "ttl life of a pinned variation")
+	apiPoll        = flag.Duration("experiments-poll", 0, "reload experiments with this fq. reloads on SIGHUP as well")
+	apiKeyring     = flag.String("keyring", "", "keyring json to sign tags and overrides. unsigned if blank")
+	apiSign        = flag.String("sign", "", "print a signed override of this variation tag and exit")
+	apiSignTTL     = flag.Duration("sign-ttl", 24*time.Hour, "validity of signed overrides")
+	apiGraduate    = flag.Duration("graduation-poll", time.Minute, "evaluate graduation policies with this fq")
 )
 
 func init() {
@@ -23,13 +33,52 @@ func init() {
 }
 
 func main() {
-	es, err := bandit.NewExperiments(bandit.NewOpener(*apiExperiments))
+	var keyring *bandit.Keyring
+	if *apiKeyring != "" {
+		var err error
+		if keyring, err = bandit.NewKeyring(bandit.NewOpener(*apiKeyring)); err != nil {
+			log.Fatalf("could not initialize keyring: %s", err.Error())
+		}
+	}
+
+	if *apiSign != "" {
+		if keyring == nil {
+			log.Fatalf("need -keyring to sign overrides")
+		}
+
+		fmt.Println(keyring.SignOverride(*apiSign, time.Now().Add(*apiSignTTL)))
+		return
+	}
+
+	es, err := bandit.NewReloader(bandit.NewOpener(*apiExperiments), *apiPoll)
 	if err != nil {
 		log.Fatalf("could not initialize experiments: %s", err.Error())
 	}
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for _ = range hup {
+			if err := es.Reload(); err != nil {
+				log.Printf("could not reload experiments: %s", err.Error())
+			}
+		}
+	}()
+
+	go func() {
+		for _ = range time.Tick(*apiGraduate) {
+			if err := es.Current().Graduate(); err != nil {
+				log.Printf("could not graduate experiments: %s", err.Error())
+			}
+		}
+	}()
+
 	m := pat.New()
-	m.Get("/experiments/:name", http.HandlerFunc(bhttp.SelectionHandler(es, *apiPinTTL)))
+	m.Get("/assignments", http.HandlerFunc(bhttp.AssignmentsHandler(es, keyring)))
+	m.Get("/experiments", http.HandlerFunc(bhttp.StatusHandler(es)))
+	m.Get("/experiments/:name/analysis", http.HandlerFunc(bhttp.AnalysisHandler(es)))
+	m.Get("/experiments/:name", http.HandlerFunc(bhttp.SelectionHandler(es, *apiPinTTL, keyring)))
+	m.Post("/experiments/:name", http.HandlerFunc(bhttp.SelectionHandler(es, *apiPinTTL, keyring)))
 	http.Handle("/", m)
 
 	// serve
@@ -14,6 +14,7 @@ import (
 	bmath "github.com/purzelrakete/bandit/math"
 	"log"
 	"math"
+	"math/rand"
 	"time"
 )
 
@@ -25,6 +26,37 @@ type Strategy interface {
 	Reset()
 }
 
+// Seedable strategies draw all random numbers from a replaceable source.
+// Seeding makes selections reproducible, e.g. for simulations or replays.
+type Seedable interface {
+	Seed(src rand.Source)
+}
+
+// bounded strategies assume rewards in [min, max], e.g. [0, 1] for Bernoulli
+// rewards.
+type bounded interface {
+	rewardRange() (float64, float64)
+}
+
+// checkReward returns an error if the reward is outside the range assumed by
+// the strategy. Strategies which are not bounded take any reward.
+func checkReward(s Strategy, reward float64) error {
+	if math.IsNaN(reward) {
+		return fmt.Errorf("reward is not a number")
+	}
+
+	b, ok := s.(bounded)
+	if !ok {
+		return nil
+	}
+
+	if min, max := b.rewardRange(); reward < min || reward > max {
+		return fmt.Errorf("reward %f not in [%g, %g]", reward, min, max)
+	}
+
+	return nil
+}
+
 // New returns an initialized stragtegy given a name like 'softmax'.
 func New(arms int, name string, params []float64) (Strategy, error) {
 	switch name {
@@ -52,12 +84,102 @@ func New(arms int, name string, params []float64) (Strategy, error) {
 		}
 
 		return NewUCB1(arms), nil
+	case "klUCB":
+		if len(params) != 1 {
+			return &klUCB{}, fmt.Errorf("missing c")
+		}
+
+		return NewKLUCB(arms, params[0])
+	case "ucb1Tuned":
+		if len(params) != 0 {
+			return &uCB1Tuned{}, fmt.Errorf("UCB1-Tuned has no parameters")
+		}
+
+		return NewUCB1Tuned(arms), nil
+	case "ucbV":
+		if len(params) != 1 {
+			return &uCBV{}, fmt.Errorf("missing ζ")
+		}
+
+		return NewUCBV(arms, params[0])
 	case "thompson":
 		if len(params) != 1 {
 			return &thompson{}, fmt.Errorf("missing α")
 		}
 
 		return NewThompson(arms, params[0])
+	case "discountedUCB":
+		if len(params) != 1 {
+			return &discountedUCB{}, fmt.Errorf("missing γ")
+		}
+
+		return NewDiscountedUCB(arms, params[0])
+	case "slidingWindowUCB":
+		if len(params) != 1 {
+			return &slidingWindowUCB{}, fmt.Errorf("missing τ")
+		}
+
+		if τ := params[0]; τ != math.Floor(τ) {
+			return &slidingWindowUCB{}, fmt.Errorf("τ not an integer")
+		}
+
+		return NewSlidingWindowUCB(arms, int(params[0]))
+	case "discountedThompson":
+		if len(params) != 2 {
+			return &discountedThompson{}, fmt.Errorf("missing γ or α")
+		}
+
+		return NewDiscountedThompson(arms, params[0], params[1])
+	case "exp3":
+		if len(params) != 1 {
+			return &exp3{}, fmt.Errorf("missing γ")
+		}
+
+		return NewEXP3(arms, params[0])
+	case "exp3p":
+		if len(params) != 3 {
+			return &exp3P{}, fmt.Errorf("missing γ, α or horizon")
+		}
+
+		if horizon := params[2]; horizon != math.Floor(horizon) {
+			return &exp3P{}, fmt.Errorf("horizon not an integer")
+		}
+
+		return NewEXP3P(arms, params[0], params[1], int(params[2]))
+	case "linucb":
+		if len(params) != 2 {
+			return &linUCB{}, fmt.Errorf("missing α or dimensions")
+		}
+
+		if d := params[1]; d != math.Floor(d) {
+			return &linUCB{}, fmt.Errorf("dimensions not an integer")
+		}
+
+		return NewLinUCB(arms, params[0], int(params[1]))
+	case "cascadeUCB":
+		if len(params) != 0 {
+			return &cascadeUCB{}, fmt.Errorf("CascadeUCB has no parameters")
+		}
+
+		return NewCascadeUCB(arms), nil
+	case "successiveElimination":
+		if len(params) != 1 {
+			return &successiveElimination{}, fmt.Errorf("missing δ")
+		}
+
+		return NewSuccessiveElimination(arms, params[0])
+	case "lucb":
+		if len(params) != 1 {
+			return &lUCB{}, fmt.Errorf("missing δ")
+		}
+
+		return NewLUCB(arms, params[0])
+	case "topTwoThompson":
+		if len(params) != 2 {
+			return &topTwoThompson{}, fmt.Errorf("missing β or δ")
+		}
+
+		return NewTopTwoThompson(arms, params[0], params[1])
 	}
 
 	return &epsilonGreedy{}, fmt.Errorf("'%s' unknown strategy", name)
@@ -98,6 +220,17 @@ func (e *epsilonGreedy) SelectArm() int {
 	return arm + 1
 }
 
+// Probabilities returns the probability of selecting each arm.
+func (e *epsilonGreedy) Probabilities() []float64 {
+	_, imax := bmath.Max(e.values)
+	ps := uniformOver(imax, e.arms)
+	for i := range ps {
+		ps[i] = e.epsilon/float64(e.arms) + (1-e.epsilon)*ps[i]
+	}
+
+	return ps
+}
+
 // String returns information on this strategy
 func (e *epsilonGreedy) String() string {
 	return fmt.Sprintf("EpsilonGreedy(epsilon=%.2f)", e.epsilon)
@@ -124,6 +257,13 @@ type softmax struct {
 
 // SelectArm returns 1 indexed arm to be tried next.
 func (s *softmax) SelectArm() int {
+	arm := draw(s.rand.Float64(), s.Probabilities())
+	s.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (s *softmax) Probabilities() []float64 {
 	max, _ := bmath.Max(s.values)
 
 	normalizer := 0.0
@@ -135,18 +275,12 @@ func (s *softmax) SelectArm() int {
 		panic("normalizer in softmax too large")
 	}
 
-	cumulativeProb := 0.0
-	draw := len(s.values) - 1
-	z := s.rand.Float64()
+	ps := make([]float64, len(s.values))
 	for i, value := range s.values {
-		cumulativeProb = cumulativeProb + math.Exp((value-max)/s.tau)/normalizer
-		if cumulativeProb > z {
-			draw = i
-			break
-		}
+		ps[i] = math.Exp((value-max)/s.tau) / normalizer
 	}
-	s.counts[draw]++
-	return draw + 1
+
+	return ps
 }
 
 // String returns information on this Strategy
@@ -168,25 +302,7 @@ type uCB1 struct {
 
 // SelectArm returns 1 indexed arm to be tried next.
 func (u *uCB1) SelectArm() int {
-	for i, count := range u.counts {
-		if count == 0 {
-			u.counts[i]++
-			return i + 1
-		}
-	}
-
-	var totalCounts int
-	for _, count := range u.counts {
-		totalCounts += count
-	}
-
-	ucbValues := make([]float64, u.arms)
-	for i := 0; i < u.arms; i++ {
-		bonus := math.Sqrt((2 * math.Log(float64(totalCounts))) / float64(u.counts[i]))
-		ucbValues[i] = u.values[i] + bonus
-	}
-
-	_, imax := bmath.Max(ucbValues)
+	imax := ucbCandidates(&u.Counters, u.bound)
 	// best arm. randomly pick because there may be equally best arms.
 	arm := imax[u.rand.Intn(len(imax))]
 
@@ -194,53 +310,95 @@ func (u *uCB1) SelectArm() int {
 	return arm + 1
 }
 
+// Probabilities returns the probability of selecting each arm.
+func (u *uCB1) Probabilities() []float64 {
+	return uniformOver(ucbCandidates(&u.Counters, u.bound), u.arms)
+}
+
+// bound is the upper confidence bound of the 0 indexed arm.
+func (u *uCB1) bound(arm int, total float64) float64 {
+	bonus := math.Sqrt((2 * math.Log(total)) / float64(u.counts[arm]))
+	return u.values[arm] + bonus
+}
+
 // String returns information on this Strategy
 func (u *uCB1) String() string {
 	return fmt.Sprintf("UCB1")
 }
 
 // NewDelayed wraps a strategy and updates internal counters from a snapshot at
-// `poll` interval.
+// `poll` interval, starting with the initial snapshot. Arms of tagged
+// snapshots are mapped by position unless the strategy belongs to an
+// experiment, which maps them by variation tag. Failed fetches keep the last
+// counters, and are recorded so that stale strategies can be detected, see
+// Freshness.
 func NewDelayed(s Strategy, o Opener, poll time.Duration) (Strategy, error) {
 	// fail once
-	if _, err := GetSnapshot(o); err != nil {
+	initial, err := GetSnapshot(o)
+	if err != nil {
 		return &delayedStrategy{}, fmt.Errorf("could not get snapshot: %s", err.Error())
 	}
 
-	c := make(chan Counters)
+	c, done := make(chan *Counters), make(chan bool)
+	strategy := &delayedStrategy{
+		strategy: s,
+		updates:  c,
+		done:     done,
+	}
+
+	strategy.initialize(initial)
+
 	go func() {
 		t := time.NewTicker(poll)
-		for _ = range t.C {
-			counters, err := GetSnapshot(o)
-			if err != nil {
-				log.Printf("Error: could not get snapshot: %s", err.Error())
+		defer t.Stop()
+		defer close(c)
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				counters, err := GetSnapshot(o)
+				if err != nil {
+					log.Printf("Error: could not get snapshot: %s", err.Error())
+					strategy.failed()
+					continue
+				}
+
+				c <- counters
 			}
-
-			c <- counters
 		}
 	}()
 
-	strategy := delayedStrategy{
-		strategy: s,
-		updates:  c,
-	}
-
 	go func() {
 		for counters := range c {
-			s.Init(&counters)
+			strategy.refresh(counters)
 		}
 	}()
 
-	return &strategy, nil
+	return strategy, nil
 }
 
 // delayedStrategy wraps a strategy. Internal counters are stored at the
 // configured source file, which is pooled at `poll` interval. The retrieved
-// Snapshot replaces the strategy's internal counters.
+// Snapshot replaces the strategy's internal counters. Snapshots with tags are
+// mapped onto arms by variation tag.
 type delayedStrategy struct {
 	Counters
-	updates  chan Counters
+	updates  chan *Counters
+	done     chan bool // closed to stop polling
 	strategy Strategy
+	tags     []string  // variation tag per arm. nil if unknown.
+	initial  *Counters // tagged initial snapshot, mapped by tag once tags are set
+
+	fetched   time.Time // time of the last successful fetch. zero until initialized.
+	generated time.Time // generation time of the last snapshot. zero if unknown.
+	failures  int       // consecutive failed fetches
+}
+
+// stop polling for snapshots.
+func (b *delayedStrategy) stop() {
+	close(b.done)
 }
 
 // SelectArm delegates to the wrapped strategy
@@ -248,50 +406,255 @@ func (b *delayedStrategy) SelectArm() int {
 	return b.strategy.SelectArm()
 }
 
+// SelectArmWithContext delegates to the wrapped strategy. Features are
+// ignored if the wrapped strategy is not contextual.
+func (b *delayedStrategy) SelectArmWithContext(features []float64) int {
+	if s, ok := b.strategy.(ContextualStrategy); ok {
+		return s.SelectArmWithContext(features)
+	}
+
+	return b.strategy.SelectArm()
+}
+
+// UpdateWithContext is a NOP, like Update.
+func (b *delayedStrategy) UpdateWithContext(arm int, features []float64, reward float64) {}
+
+// slate returns the wrapped strategy if it can select slates. Like Update,
+// UpdateSlate of the returned strategy is a NOP.
+func (b *delayedStrategy) slate() (SlateStrategy, error) {
+	s, ok := b.strategy.(SlateStrategy)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot select slates", b.strategy)
+	}
+
+	return delayedSlate{s}, nil
+}
+
+// delayedSlate selects slates with the slate strategy wrapped by a delayed
+// strategy, which is only updated from snapshots.
+type delayedSlate struct {
+	SlateStrategy
+}
+
+// UpdateSlate is a NOP, like Update.
+func (d delayedSlate) UpdateSlate(arms []int, rewards []float64) {}
+
+// pull delegates to the wrapped strategy.
+func (b *delayedStrategy) pull(arm int) {
+	if s, ok := b.strategy.(puller); ok {
+		s.pull(arm)
+	}
+}
+
+// Snapshot delegates to the wrapped strategy.
+func (b *delayedStrategy) Snapshot() *Counters {
+	if s, ok := b.strategy.(snapshotter); ok {
+		return s.Snapshot()
+	}
+
+	return b.Counters.Snapshot()
+}
+
+// Done delegates to the wrapped strategy. It is never confident if the
+// wrapped strategy does not identify best arms.
+func (b *delayedStrategy) Done() (int, bool) {
+	if s, ok := b.strategy.(BestArmIdentifier); ok {
+		return s.Done()
+	}
+
+	return 0, false
+}
+
+// Dimensions delegates to the wrapped strategy. It is 0 if the wrapped
+// strategy is not contextual.
+func (b *delayedStrategy) Dimensions() int {
+	if s, ok := b.strategy.(ContextualStrategy); ok {
+		return s.Dimensions()
+	}
+
+	return 0
+}
+
 // String gives information about delayed strategy + the wrapped strategy.
 func (b *delayedStrategy) String() string {
 	return fmt.Sprintf("Delayed(%b)", b.strategy)
 }
 
 // DelayedUpdate updates the internal counters of a strategy with the provided
-// counters.
+// counters. Arms missing from a tagged snapshot keep their prior.
 func (b *delayedStrategy) Init(c *Counters) error {
 	b.Lock()
 	defer b.Unlock()
+
+	if c.tags != nil && b.tags != nil {
+		c = c.byTags(b.tags, &b.Counters)
+	}
+
 	return b.strategy.Init(c)
 }
 
+// setTags sets the variation tag of each arm. Priors are reset if the number
+// of arms changed. The strategy is initialized with a pending initial
+// snapshot, which can now be mapped onto arms.
+func (b *delayedStrategy) setTags(tags []string) {
+	b.Lock()
+	if b.arms != len(tags) {
+		b.arms = len(tags)
+		b.Counters.Reset()
+	}
+
+	b.tags = tags
+	initial := b.initial
+	b.initial = nil
+	b.Unlock()
+
+	if initial != nil {
+		b.refresh(initial)
+	}
+}
+
+// initialize initializes the strategy with the initial snapshot. Tagged
+// snapshots are mapped onto arms by position until the tags of the arms are
+// set, e.g. outside of experiments, and again by tag once they are.
+// Snapshots which do not fit the arms by position wait for the tags.
+func (b *delayedStrategy) initialize(c *Counters) {
+	b.Lock()
+	pending := c.tags != nil && b.tags == nil
+	if pending {
+		b.initial = c
+	}
+	b.Unlock()
+
+	if !pending {
+		b.refresh(c)
+		return
+	}
+
+	if err := b.Init(c); err == nil {
+		b.succeeded(c)
+	}
+}
+
+// addArm adds the arm to the wrapped strategy and keeps its prior for tagged
+// snapshots.
+func (b *delayedStrategy) addArm(count int, value float64) {
+	b.Counters.addArm(count, value)
+	if s, ok := b.strategy.(resizable); ok {
+		s.addArm(count, value)
+	}
+}
+
+// removeArm removes the 1 indexed arm from the wrapped strategy.
+func (b *delayedStrategy) removeArm(arm int) {
+	b.Counters.removeArm(arm)
+	if s, ok := b.strategy.(resizable); ok {
+		s.removeArm(arm)
+	}
+}
+
+// Seed delegates to the wrapped strategy.
+func (b *delayedStrategy) Seed(src rand.Source) {
+	if s, ok := b.strategy.(Seedable); ok {
+		s.Seed(src)
+	}
+}
+
+// Probabilities delegates to the wrapped strategy. Returns nil if the wrapped
+// strategy is not probabilistic.
+func (b *delayedStrategy) Probabilities() []float64 {
+	if s, ok := b.strategy.(Probabilistic); ok {
+		return s.Probabilities()
+	}
+
+	return nil
+}
+
+// ProbabilitiesWithContext delegates to the wrapped strategy.
+func (b *delayedStrategy) ProbabilitiesWithContext(features []float64) []float64 {
+	if s, ok := b.strategy.(contextualProbabilistic); ok {
+		return s.ProbabilitiesWithContext(features)
+	}
+
+	return b.Probabilities()
+}
+
+// rewardRange delegates to the wrapped strategy. Any reward is in range if the
+// wrapped strategy is not bounded.
+func (b *delayedStrategy) rewardRange() (float64, float64) {
+	if s, ok := b.strategy.(bounded); ok {
+		return s.rewardRange()
+	}
+
+	return math.Inf(-1), math.Inf(1)
+}
+
 // Update is a NOP. Delayed strategy is updated with Reset(counter) instead
 func (b *delayedStrategy) Update(arm int, reward float64) {}
 
-// NewThompson constructs a thompson sampling strategy.
+// RewardModel is the reward distribution assumed by thompson sampling. Each
+// model samples from the conjugate posterior of an arm's mean reward.
+type RewardModel string
+
+const (
+	// Bernoulli rewards in {0, 1}, e.g. clicks. Beta posterior.
+	Bernoulli RewardModel = "bernoulli"
+
+	// Gaussian rewards with unknown mean and variance, e.g. revenue or
+	// dwell time. Normal-Gamma posterior.
+	Gaussian RewardModel = "gaussian"
+
+	// Poisson rewards, e.g. number of items added to a cart. Gamma
+	// posterior.
+	Poisson RewardModel = "poisson"
+)
+
+// NewThompson constructs a thompson sampling strategy for Bernoulli rewards.
 func NewThompson(arms int, α float64) (Strategy, error) {
+	return NewThompsonModel(arms, α, Bernoulli)
+}
+
+// NewThompsonModel constructs a thompson sampling strategy for the given
+// reward model. α is the strength of the prior, in pseudo observations.
+func NewThompsonModel(arms int, α float64, model RewardModel) (Strategy, error) {
 	if !(α > 0.0) {
 		return &thompson{}, fmt.Errorf("α not in (0, ∞]")
 	}
 
+	switch model {
+	case Bernoulli, Gaussian, Poisson:
+	default:
+		return &thompson{}, fmt.Errorf("'%s' unknown reward model", model)
+	}
+
+	// one source for all draws, like Seed. equally seeded samplers would
+	// draw correlated samples.
+	src := rand.NewSource(time.Now().UnixNano())
 	return &thompson{
-		Counters: NewCounters(arms),
-		alpha:    α,
-		betaRand: bmath.NewBetaRand(time.Now().UnixNano()),
+		Counters:  NewCountersSource(arms, src),
+		alpha:     α,
+		model:     model,
+		betaRand:  bmath.NewBetaRandSource(src),
+		gammaRand: bmath.NewGammaRandSource(src),
 	}, nil
 }
 
-// Thompson sampling (for Bernoulli strategys) explores arms by sampling
-// according to the probability that it maximizes the expected reward.
+// Thompson sampling explores arms by sampling according to the probability
+// that it maximizes the expected reward.
 type thompson struct {
 	Counters
-	betaRand *bmath.BetaRand
-	alpha    float64 // strength of prior distributionr. beta with homogeneous prior
+	betaRand  *bmath.BetaRand
+	gammaRand *bmath.GammaRand
+	alpha     float64     // strength of prior distributionr. beta with homogeneous prior
+	model     RewardModel // reward distribution
+	estimates estimates   // cached selection probabilities
+	slates    inclusions  // cached slate probabilities
 }
 
 // SelectArm returns 1 indexed arm to be tried next.
 func (t *thompson) SelectArm() int {
 	var thetas = make([]float64, t.arms)
 	for i := 0; i < t.arms; i++ {
-		si := t.values[i] * float64(t.counts[i])
-		fi := float64(t.counts[i]) - si
-		thetas[i] = t.betaRand.NextBeta(si+t.alpha, fi+t.alpha)
+		thetas[i] = t.sample(i)
 	}
 
 	_, imax := bmath.Max(thetas)
@@ -302,7 +665,61 @@ func (t *thompson) SelectArm() int {
 	return arm + 1
 }
 
+// Seed replaces the random source of the strategy and its samplers.
+func (t *thompson) Seed(src rand.Source) {
+	t.Counters.Seed(src)
+	t.betaRand = bmath.NewBetaRandSource(src)
+	t.gammaRand = bmath.NewGammaRandSource(src)
+}
+
+// Probabilities returns Monte Carlo estimates of the probability of selecting
+// each arm. Estimates are cached until the next reward or snapshot.
+func (t *thompson) Probabilities() []float64 {
+	return t.estimates.get(&t.Counters, func() []float64 {
+		return estimateProbabilities(t.arms, t.sample)
+	})
+}
+
+// sample draws the mean reward of the 0 indexed arm from its posterior.
+// Priors are worth α observations of mean 0.5 (bernoulli), mean 0 and
+// variance 1 (gaussian) or mean 1 (poisson).
+func (t *thompson) sample(arm int) float64 {
+	n := float64(t.counts[arm])
+	mean := t.values[arm]
+
+	switch t.model {
+	case Gaussian:
+		κ := t.alpha + n
+		μ := n * mean / κ
+		α := (t.alpha + n) / 2
+		β := t.alpha/2 + n*t.variance(arm)/2 + t.alpha*n*mean*mean/(2*κ)
+		return t.gammaRand.NextNormalGamma(μ, κ, α, β)
+	case Poisson:
+		return t.gammaRand.NextGamma(t.alpha+n*mean, t.alpha+n)
+	}
+
+	si := mean * n
+	fi := n - si
+	return t.betaRand.NextBeta(si+t.alpha, fi+t.alpha)
+}
+
+// rewardRange returns the support of the reward model.
+func (t *thompson) rewardRange() (float64, float64) {
+	switch t.model {
+	case Gaussian:
+		return math.Inf(-1), math.Inf(1)
+	case Poisson:
+		return 0, math.Inf(1)
+	}
+
+	return 0, 1
+}
+
 // String returns information on this strategy
 func (t *thompson) String() string {
+	if t.model != Bernoulli {
+		return fmt.Sprintf("Thompson(alpha=%.2f, model=%s)", t.alpha, t.model)
+	}
+
 	return fmt.Sprintf("Thompson(alpha=%.2f)", t.alpha)
 }
@@ -7,7 +7,11 @@ import (
 	bmath "github.com/purzelrakete/bandit/math"
 	"github.com/purzelrakete/bandit/sim"
 	"math"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEpsilonGreedy(t *testing.T) {
@@ -269,3 +273,628 @@ func TestThompson(t *testing.T) {
 		t.Fatalf("cumulative performance should be > %f. is %f", expectedCumulative, got)
 	}
 }
+
+func TestLinUCB(t *testing.T) {
+	trials := 2000
+	contexts := [][]float64{{1, 0}, {0, 1}}
+	best := []int{1, 2} // best arm in each context
+	arms := [][]sim.Arm{
+		{bmath.BernRand(0.8), bmath.BernRand(0.2)},
+		{bmath.BernRand(0.1), bmath.BernRand(0.7)},
+	}
+
+	strategy, err := NewLinUCB(2, 0.5, 2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	l := strategy.(ContextualStrategy)
+	correct := 0
+	for trial := 0; trial < trials; trial++ {
+		c := trial % len(contexts)
+		selected := l.SelectArmWithContext(contexts[c])
+		l.UpdateWithContext(selected, contexts[c], arms[c][selected-1]())
+
+		if trial >= trials/2 && selected == best[c] {
+			correct++
+		}
+	}
+
+	if got := float64(correct) / float64(trials/2); got < 0.9 {
+		t.Fatalf("accuracy is only %f after %d trials", got, trials)
+	}
+}
+
+func TestLinUCBSnapshot(t *testing.T) {
+	// arm 1 pays with feature 1, arm 2 without
+	snapshot, err := DecodeSnapshot(strings.NewReader(`{"version": 2, "arms": [
+		{"tag": "shape:1", "count": 20, "sum": 10,
+		 "regression": {"gram": [[10, 10], [10, 20]], "moments": [10, 10]}},
+		{"tag": "shape:2", "count": 20, "sum": 10,
+		 "regression": {"gram": [[10, 10], [10, 20]], "moments": [0, 10]}}
+	]}`))
+	if err != nil {
+		t.Fatalf("could not decode snapshot: %s", err.Error())
+	}
+
+	strategy, err := NewLinUCB(2, 0, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if err := strategy.Init(snapshot.Counters()); err != nil {
+		t.Fatalf("could not init snapshot: %s", err.Error())
+	}
+
+	l := strategy.(ContextualStrategy)
+	if got := l.SelectArmWithContext([]float64{1}); got != 1 {
+		t.Fatalf("expected arm 1 with feature 1, got %d", got)
+	}
+
+	if got := l.SelectArmWithContext([]float64{0}); got != 2 {
+		t.Fatalf("expected arm 2 without feature 1, got %d", got)
+	}
+}
+
+func TestThompsonGaussian(t *testing.T) {
+	α := 1.0
+	sims := 1000
+	trials := 300
+	bestArmIndex := 2 // Gaussian(bestArm)
+	bestArm := 12.0
+	arms := []sim.Arm{
+		bmath.NormRand(10, 5),
+		bmath.NormRand(bestArm, 5),
+		bmath.NormRand(4, 5),
+	}
+
+	strategy, err := NewThompsonModel(len(arms), α, Gaussian)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	s, err := sim.MonteCarlo(sims, trials, arms, strategy)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+	if got := accuracies[len(accuracies)-1]; got < 0.8 {
+		t.Fatalf("accuracy is only %f. %d sims, %d trials", got, sims, trials)
+	}
+
+	performances := sim.Performance(&s)
+	if got := performances[len(performances)-1]; math.Abs(bestArm-got) > 1 {
+		t.Fatalf("performance converge to %f. is %f", bestArm, got)
+	}
+}
+
+func TestThompsonPoisson(t *testing.T) {
+	α := 1.0
+	sims := 1000
+	trials := 300
+	bestArmIndex := 3 // Poisson(bestArm)
+	bestArm := 3.0
+	arms := []sim.Arm{
+		bmath.PoissRand(1),
+		bmath.PoissRand(2),
+		bmath.PoissRand(bestArm),
+	}
+
+	strategy, err := NewThompsonModel(len(arms), α, Poisson)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	s, err := sim.MonteCarlo(sims, trials, arms, strategy)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+	if got := accuracies[len(accuracies)-1]; got < 0.9 {
+		t.Fatalf("accuracy is only %f. %d sims, %d trials", got, sims, trials)
+	}
+
+	performances := sim.Performance(&s)
+	if got := performances[len(performances)-1]; math.Abs(bestArm-got) > 0.3 {
+		t.Fatalf("performance converge to %f. is %f", bestArm, got)
+	}
+}
+
+func TestNonStationary(t *testing.T) {
+	sims := 500
+	trials := 1000
+	changeAt := 500
+	recovery := 100   // trials after change point
+	bestArmIndex := 2 // best arm after the change point
+	before := []sim.Arm{bmath.BernRand(0.8), bmath.BernRand(0.2)}
+	after := []sim.Arm{bmath.BernRand(0.2), bmath.BernRand(0.8)}
+	scenario := sim.ChangePoint(changeAt, before, after)
+
+	// stationary thompson sampling is dominated by data before the change
+	thompson, err := NewThompson(len(before), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	dUCB, err := NewDiscountedUCB(len(before), 0.95)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	swUCB, err := NewSlidingWindowUCB(len(before), 50)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	dThompson, err := NewDiscountedThompson(len(before), 0.95, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	s, err := sim.MonteCarloScenario(sims, trials, scenario, thompson)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+	stuck := accuracies[changeAt+recovery]
+	if stuck > 0.5 {
+		t.Fatalf("stationary accuracy is %f after change point", stuck)
+	}
+
+	for _, strategy := range []Strategy{dUCB, swUCB, dThompson} {
+		s, err := sim.MonteCarloScenario(sims, trials, scenario, strategy)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+		if got := accuracies[changeAt+recovery]; got < 0.7 {
+			t.Fatalf("%s accuracy is only %f after change point", strategy, got)
+		}
+	}
+}
+
+func TestEXP3(t *testing.T) {
+	sims := 1000
+	trials := 1000
+	bestArmIndex := 3 // Bernoulli(bestArm)
+	bestArm := 0.8
+	arms := []sim.Arm{
+		bmath.BernRand(0.1),
+		bmath.BernRand(0.2),
+		bmath.BernRand(bestArm),
+	}
+
+	exp3, err := NewEXP3(len(arms), 0.1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	exp3P, err := NewEXP3P(len(arms), 0.1, 0.1, trials)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, strategy := range []Strategy{exp3, exp3P} {
+		s, err := sim.MonteCarlo(sims, trials, arms, strategy)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+		if got := accuracies[len(accuracies)-1]; got < 0.85 {
+			t.Fatalf("%s accuracy is only %f. %d sims, %d trials", strategy, got, sims, trials)
+		}
+
+		sum := 0.0
+		for _, p := range strategy.(Probabilistic).Probabilities() {
+			sum += p
+		}
+
+		if math.Abs(sum-1) > 1e-9 {
+			t.Fatalf("%s probabilities sum to %f", strategy, sum)
+		}
+	}
+}
+
+func TestUCBVariants(t *testing.T) {
+	sims := 500
+	trials := 1000
+	bestArmIndex := 4 // Bernoulli(bestArm)
+	bestArm := 0.8
+	arms := []sim.Arm{
+		bmath.BernRand(0.1),
+		bmath.BernRand(0.3),
+		bmath.BernRand(0.2),
+		bmath.BernRand(bestArm),
+	}
+
+	klUCB, err := NewKLUCB(len(arms), 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	ucbV, err := NewUCBV(len(arms), 1.2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, strategy := range []Strategy{klUCB, NewUCB1Tuned(len(arms)), ucbV} {
+		s, err := sim.MonteCarlo(sims, trials, arms, strategy)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		accuracies := sim.Accuracy([]int{bestArmIndex})(&s)
+		if got := accuracies[len(accuracies)-1]; got < 0.9 {
+			t.Fatalf("%s accuracy is only %f. %d sims, %d trials", strategy, got, sims, trials)
+		}
+
+		performances := sim.Performance(&s)
+		if got := performances[len(performances)-1]; math.Abs(bestArm-got) > 0.1 {
+			t.Fatalf("%s performance converge to %f. is %f", strategy, bestArm, got)
+		}
+	}
+}
+
+func TestProbabilities(t *testing.T) {
+	arms := 3
+	specs := []struct {
+		name   string
+		params []float64
+	}{
+		{"epsilonGreedy", []float64{0.1}},
+		{"softmax", []float64{0.1}},
+		{"ucb1", []float64{}},
+		{"thompson", []float64{1}},
+		{"klUCB", []float64{0}},
+		{"ucb1Tuned", []float64{}},
+		{"ucbV", []float64{1.2}},
+		{"discountedUCB", []float64{0.9}},
+		{"slidingWindowUCB", []float64{10}},
+		{"discountedThompson", []float64{0.9, 1}},
+		{"exp3", []float64{0.1}},
+		{"exp3p", []float64{0.1, 0.1, 100}},
+		{"linucb", []float64{1, 2}},
+		{"cascadeUCB", []float64{}},
+		{"successiveElimination", []float64{0.05}},
+		{"lucb", []float64{0.05}},
+		{"topTwoThompson", []float64{0.5, 0.05}},
+	}
+
+	for _, spec := range specs {
+		strategy, err := New(arms, spec.name, spec.params)
+		if err != nil {
+			t.Fatalf("%s: %s", spec.name, err.Error())
+		}
+
+		for i := 0; i < 20; i++ {
+			arm := strategy.SelectArm()
+			strategy.Update(arm, float64(arm%2))
+		}
+
+		p, ok := strategy.(Probabilistic)
+		if !ok {
+			t.Fatalf("%s does not report probabilities", strategy)
+		}
+
+		sum := 0.0
+		for _, p := range p.Probabilities() {
+			if p < 0 || p > 1 {
+				t.Fatalf("%s has probability %f", strategy, p)
+			}
+
+			sum += p
+		}
+
+		if math.Abs(sum-1) > 1e-9 {
+			t.Fatalf("%s probabilities sum to %f", strategy, sum)
+		}
+	}
+}
+
+func TestThompsonPropensities(t *testing.T) {
+	strategy, err := NewThompson(2, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the second arm is never the best sample
+	snapshot := NewCounters(2)
+	snapshot.counts = []int{10000, 10000}
+	snapshot.values = []float64{0.9, 0.1}
+	if err := strategy.Init(&snapshot); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	ps := strategy.(Probabilistic).Probabilities()
+	if ps[1] <= 0 {
+		t.Fatalf("expected a positive propensity for every arm, got %v", ps)
+	}
+
+	strategy.SelectArm()
+	if again := strategy.(Probabilistic).Probabilities(); !reflect.DeepEqual(ps, again) {
+		t.Fatalf("expected cached estimates until the next reward, got %v and %v", ps, again)
+	}
+
+	strategy.Update(2, 1)
+	if got := strategy.(*thompson).estimates.version; got == strategy.(*thompson).version {
+		t.Fatalf("expected estimates to be stale after a reward")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	sims := 10
+	trials := 100
+	names := []struct {
+		name   string
+		params []float64
+	}{
+		{"epsilonGreedy", []float64{0.1}},
+		{"thompson", []float64{1}},
+		{"discountedThompson", []float64{0.9, 1}},
+		{"exp3", []float64{0.1}},
+	}
+
+	run := func(name string, params []float64) sim.Simulation {
+		strategy, err := New(2, name, params)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		strategy.(Seedable).Seed(rand.NewSource(42))
+		arms := []sim.Arm{
+			bmath.BernRandSource(0.1, rand.NewSource(1)),
+			bmath.BernRandSource(0.2, rand.NewSource(2)),
+		}
+
+		s, err := sim.MonteCarlo(sims, trials, arms, strategy)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+
+		return s
+	}
+
+	for _, n := range names {
+		a, b := run(n.name, n.params), run(n.name, n.params)
+		for i := range a.Selected {
+			if a.Selected[i] != b.Selected[i] || a.Reward[i] != b.Reward[i] {
+				t.Fatalf("%s: seeded runs differ at %d", n.name, i)
+			}
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	specs := []struct {
+		name   string
+		params []float64
+	}{
+		{"epsilonGreedy", []float64{0.1}},
+		{"ucb1", []float64{}},
+		{"thompson", []float64{1}},
+		{"slidingWindowUCB", []float64{10}},
+		{"discountedThompson", []float64{0.9, 1}},
+		{"exp3p", []float64{0.1, 0.1, 100}},
+		{"linucb", []float64{1, 2}},
+		{"successiveElimination", []float64{0.05}},
+		{"lucb", []float64{0.05}},
+	}
+
+	for _, spec := range specs {
+		strategy, err := New(2, spec.name, spec.params)
+		if err != nil {
+			t.Fatalf("%s: %s", spec.name, err.Error())
+		}
+
+		for i := 0; i < 20; i++ {
+			arm := strategy.SelectArm()
+			strategy.Update(arm, float64(arm%2))
+		}
+
+		r := strategy.(resizable)
+		r.addArm(10, 0.5)
+		r.removeArm(1)
+		for i := 0; i < 20; i++ {
+			arm := strategy.SelectArm()
+			if arm < 1 || arm > 2 {
+				t.Fatalf("%s selected arm %d of 2", strategy, arm)
+			}
+
+			strategy.Update(arm, 1)
+		}
+
+		if got := len(strategy.(Probabilistic).Probabilities()); got != 2 {
+			t.Fatalf("%s has %d probabilities, expected 2", strategy, got)
+		}
+	}
+}
+
+func TestDelayedTaggedSnapshot(t *testing.T) {
+	strategy, err := NewThompson(2, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	delayed := &delayedStrategy{strategy: strategy}
+	delayed.setTags([]string{"shape:1", "shape:2"})
+	delayed.addArm(5, 0.9)
+	delayed.setTags([]string{"shape:1", "shape:2", "shape:3"})
+
+	snapshot := NewCounters(2)
+	snapshot.values = []float64{0.2, 0.1}
+	snapshot.tags = []string{"shape:2", "shape:1"}
+	if err := delayed.Init(&snapshot); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	values := strategy.(*thompson).values
+	for i, expected := range []float64{0.1, 0.2, 0.9} {
+		if got := values[i]; got != expected {
+			t.Fatalf("expected arm %d to have value %f, got %f", i+1, expected, got)
+		}
+	}
+}
+
+func TestDelayedUntagged(t *testing.T) {
+	strategy, err := NewThompson(2, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	snapshot := stringOpener(`{"version": 2, "experiment": "shape", "arms": [
+		{"tag": "shape:1", "count": 10, "sum": 2},
+		{"tag": "shape:2", "count": 10, "sum": 1}
+	]}`)
+
+	// outside of experiments, tags are never set
+	d, err := NewDelayed(strategy, snapshot, time.Hour)
+	if err != nil {
+		t.Fatalf("could not make delayed strategy: %s", err.Error())
+	}
+
+	defer stopStrategy(d)
+
+	if got := strategy.(*thompson).values; got[0] != 0.2 || got[1] != 0.1 {
+		t.Fatalf("expected snapshot to be mapped by position, got %v", got)
+	}
+
+	if f := d.(*delayedStrategy).freshness(Staleness{}, time.Now()); f.Fetched.IsZero() {
+		t.Fatalf("expected snapshot to be fetched, got %v", f)
+	}
+}
+
+func TestDelayedSlate(t *testing.T) {
+	softmax, err := NewSoftmax(2, 0.1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if _, err := slateStrategy(&delayedStrategy{strategy: softmax}); err == nil {
+		t.Fatalf("expected delayed softmax not to select slates")
+	}
+
+	strategy, err := NewThompson(2, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	s, err := slateStrategy(&delayedStrategy{strategy: strategy})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	if got := s.SelectArms(2); len(got) != 2 {
+		t.Fatalf("expected slate of 2 arms, got %v", got)
+	}
+
+	s.UpdateSlate([]int{1, 2}, []float64{1, 1})
+	if got := strategy.(*thompson).values; got[0] != 0 || got[1] != 0 {
+		t.Fatalf("expected delayed slate not to be updated, got %v", got)
+	}
+}
+
+func TestSlate(t *testing.T) {
+	sims := 100
+	trials := 1000
+	k := 2
+	attractions := []float64{0.05, 0.1, 0.2, 0.5, 0.6}
+	best := map[int]bool{4: true, 5: true}
+
+	thompson, err := NewThompson(len(attractions), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	cascade := NewCascadeUCB(len(attractions))
+	for _, strategy := range []SlateStrategy{thompson.(SlateStrategy), cascade.(SlateStrategy)} {
+		strategy.(Seedable).Seed(rand.NewSource(1))
+		clicks := rand.New(rand.NewSource(2))
+
+		correct := 0
+		for sim := 0; sim < sims; sim++ {
+			strategy.Reset()
+
+			var arms []int
+			for trial := 0; trial < trials; trial++ {
+				// cascade model: click on the first attractive arm
+				arms = strategy.SelectArms(k)
+				rewards := make([]float64, k)
+				for i, arm := range arms {
+					if clicks.Float64() < attractions[arm-1] {
+						rewards[i] = 1
+						break
+					}
+				}
+
+				strategy.UpdateSlate(arms, rewards)
+			}
+
+			if best[arms[0]] && best[arms[1]] {
+				correct++
+			}
+		}
+
+		if got := float64(correct) / float64(sims); got < 0.8 {
+			t.Fatalf("%s accuracy is only %f. %d sims, %d trials", strategy, got, sims, trials)
+		}
+	}
+}
+
+func TestBestArmIdentification(t *testing.T) {
+	sims := 20
+	trials := 5000
+	bestArmIndex := 3
+	mus := []float64{0.2, 0.4, 0.7}
+
+	successiveElimination, err := NewSuccessiveElimination(len(mus), 0.05)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	lucb, err := NewLUCB(len(mus), 0.05)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	topTwo, err := NewTopTwoThompson(len(mus), 0.5, 0.05)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	for _, strategy := range []Strategy{successiveElimination, lucb, topTwo} {
+		strategy.(Seedable).Seed(rand.NewSource(1))
+		var arms []sim.Arm
+		for i, μ := range mus {
+			arms = append(arms, bmath.BernRandSource(μ, rand.NewSource(int64(i))))
+		}
+
+		identifier := strategy.(BestArmIdentifier)
+		for sim := 0; sim < sims; sim++ {
+			strategy.Reset()
+
+			confident, winner := false, 0
+			for trial := 1; trial <= trials && !confident; trial++ {
+				arm := strategy.SelectArm()
+				strategy.Update(arm, arms[arm-1]())
+
+				// the posterior probability of topTwo is expensive
+				if trial%50 == 0 {
+					winner, confident = identifier.Done()
+				}
+			}
+
+			if !confident {
+				t.Fatalf("%s not confident after %d trials", strategy, trials)
+			}
+
+			if winner != bestArmIndex {
+				t.Fatalf("%s declared arm %d the winner", strategy, winner)
+			}
+		}
+	}
+}
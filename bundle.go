@@ -0,0 +1,233 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Bundle holds the version 2 snapshots of many experiments, keyed by
+// experiment name. Segments are keyed by their name, e.g. shape@de. Bundles
+// are encoded as json:
+//
+//     {
+//       "version": 2,
+//       "generated": "2013-09-13T10:00:00Z",
+//       "job-version": "1.2",
+//       "snapshots": {
+//         "shape-20130822": {"version": 2, "experiment": "shape-20130822", ...},
+//         "plants-20121111": {"version": 2, "experiment": "plants-20121111", ...}
+//       }
+//     }
+type Bundle struct {
+	Version    int                 `json:"version"`
+	Generated  time.Time           `json:"generated"`
+	JobVersion string              `json:"job-version"`
+	Snapshots  map[string]Snapshot `json:"snapshots"`
+}
+
+// DecodeBundle reads a bundle.
+func DecodeBundle(r io.Reader) (Bundle, error) {
+	var b Bundle
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return Bundle{}, fmt.Errorf("could not decode bundle: %s", err.Error())
+	}
+
+	if b.Version != SnapshotVersion {
+		return Bundle{}, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	for name, s := range b.Snapshots {
+		if s.Version != SnapshotVersion {
+			return Bundle{}, fmt.Errorf("%s: unsupported snapshot version %d", name, s.Version)
+		}
+	}
+
+	return b, nil
+}
+
+// GetBundle returns the bundle given an opener.
+func GetBundle(o Opener) (Bundle, error) {
+	reader, err := o.Open()
+	if err != nil {
+		return Bundle{}, fmt.Errorf("could not open: %s", err.Error())
+	}
+
+	defer reader.Close()
+
+	return DecodeBundle(reader)
+}
+
+// NewBundled wraps a strategy and updates its counters from snapshot `name`
+// of the bundle at `location`. All strategies referencing a bundle share a
+// single poller, which polls at the interval of the first strategy.
+// Strategies start with their snapshot in the last fetched bundle. Strategies
+// whose snapshot is missing from the bundle keep their counters, and count it
+// as a failed fetch.
+func NewBundled(s Strategy, location, name string, poll time.Duration) (Strategy, error) {
+	strategy := &delayedStrategy{
+		strategy: s,
+		done:     make(chan bool),
+	}
+
+	p, err := subscribe(location, poll, strategy, name)
+	if err != nil {
+		return &delayedStrategy{}, fmt.Errorf("could not get bundle: %s", err.Error())
+	}
+
+	go func() {
+		<-strategy.done
+		p.remove(strategy)
+	}()
+
+	return strategy, nil
+}
+
+var (
+	pollersMu sync.Mutex
+	pollers   = make(map[string]*bundlePoller) // by bundle location
+)
+
+// bundlePoller polls a bundle and distributes its snapshots to the
+// strategies referencing it.
+type bundlePoller struct {
+	location    string
+	opener      Opener
+	mu          sync.Mutex
+	subscribers map[*delayedStrategy]string // strategy -> snapshot name
+	done        chan bool                   // closed when the last strategy leaves
+	last        Bundle                      // last fetched bundle
+}
+
+// subscribe subscribes the strategy to snapshot `name` of the bundle at
+// `location`, and returns the bundle's poller. Pollers are started by their
+// first strategy, and fail if the bundle cannot be read.
+func subscribe(location string, poll time.Duration, strategy *delayedStrategy, name string) (*bundlePoller, error) {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+
+	if p, ok := pollers[location]; ok {
+		p.add(strategy, name)
+		return p, nil
+	}
+
+	// fail once
+	opener := NewOpener(location)
+	bundle, err := GetBundle(opener)
+	if err != nil {
+		return &bundlePoller{}, err
+	}
+
+	p := &bundlePoller{
+		location:    location,
+		opener:      opener,
+		subscribers: make(map[*delayedStrategy]string),
+		done:        make(chan bool),
+		last:        bundle,
+	}
+
+	pollers[location] = p
+	p.add(strategy, name)
+	go p.poll(poll)
+	return p, nil
+}
+
+// poll distributes the bundle every `poll` interval until the last strategy
+// leaves.
+func (p *bundlePoller) poll(poll time.Duration) {
+	t := time.NewTicker(poll)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			bundle, err := GetBundle(p.opener)
+			if err != nil {
+				log.Printf("Error: could not get bundle: %s", err.Error())
+				p.fail()
+				continue
+			}
+
+			p.distribute(bundle)
+		}
+	}
+}
+
+// distribute initializes each strategy with its snapshot in the bundle.
+func (p *bundlePoller) distribute(bundle Bundle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.last = bundle
+	for strategy, name := range p.subscribers {
+		counters, ok := p.snapshot(name)
+		if !ok {
+			strategy.failed()
+			continue
+		}
+
+		strategy.refresh(counters)
+	}
+}
+
+// snapshot returns the counters of snapshot `name` in the last bundle.
+// Missing snapshots are logged. Callers hold the lock.
+func (p *bundlePoller) snapshot(name string) (*Counters, bool) {
+	snapshot, ok := p.last.Snapshots[name]
+	if !ok {
+		log.Printf("Error: bundle %s has no snapshot %s", p.location, name)
+		return nil, false
+	}
+
+	return snapshot.Counters(), true
+}
+
+// fail records a failed fetch on every strategy.
+func (p *bundlePoller) fail() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for strategy := range p.subscribers {
+		strategy.failed()
+	}
+}
+
+// add subscribes the strategy to snapshot `name`, and initializes it with
+// its snapshot in the last bundle.
+func (p *bundlePoller) add(strategy *delayedStrategy, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribers[strategy] = name
+	counters, ok := p.snapshot(name)
+	if !ok {
+		strategy.failed()
+		return
+	}
+
+	strategy.initialize(counters)
+}
+
+// remove unsubscribes the strategy, and stops polling once no strategies are
+// left.
+func (p *bundlePoller) remove(strategy *delayedStrategy) {
+	pollersMu.Lock()
+	defer pollersMu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.subscribers, strategy)
+	if len(p.subscribers) == 0 {
+		close(p.done)
+		delete(pollers, p.location)
+	}
+}
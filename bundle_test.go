@@ -0,0 +1,96 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBundle(t *testing.T) {
+	file, err := ioutil.TempFile("", "bundle")
+	if err != nil {
+		t.Fatalf("could not create bundle: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{
+		"version": 2,
+		"snapshots": {
+			"shape": {"version": 2, "experiment": "shape", "arms": [
+				{"tag": "shape:1", "count": 10, "sum": 1},
+				{"tag": "shape:2", "count": 10, "sum": 9}
+			]},
+			"fonts": {"version": 2, "experiment": "fonts", "arms": [
+				{"tag": "fonts:1", "count": 4, "sum": 4},
+				{"tag": "fonts:2", "count": 4, "sum": 0}
+			]}
+		}
+	}`)
+	file.Close()
+
+	bundled := func(name string) *delayedStrategy {
+		s, err := NewEpsilonGreedy(2, 0.1)
+		if err != nil {
+			t.Fatalf("could not make strategy: %s", err.Error())
+		}
+
+		d, err := NewBundled(s, file.Name(), name, 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("could not make bundled strategy: %s", err.Error())
+		}
+
+		d.(*delayedStrategy).setTags([]string{name + ":1", name + ":2"})
+		return d.(*delayedStrategy)
+	}
+
+	shape, fonts := bundled("shape"), bundled("fonts")
+
+	pollersMu.Lock()
+	shared := len(pollers)
+	pollersMu.Unlock()
+
+	if shared != 1 {
+		t.Fatalf("expected strategies to share a poller, got %d", shared)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for shape.Snapshot().counts[0] == 0 || fonts.Snapshot().counts[0] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected counters from the bundle")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := shape.Snapshot().values; got[0] != 0.1 || got[1] != 0.9 {
+		t.Fatalf("unexpected shape rewards %v", got)
+	}
+
+	if got := fonts.Snapshot().values; got[0] != 1 || got[1] != 0 {
+		t.Fatalf("unexpected fonts rewards %v", got)
+	}
+
+	stopStrategy(shape)
+	stopStrategy(fonts)
+
+	deadline = time.Now().Add(time.Second)
+	for {
+		pollersMu.Lock()
+		left := len(pollers)
+		pollersMu.Unlock()
+
+		if left == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected poller to stop with its last strategy")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
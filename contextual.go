@@ -0,0 +1,224 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+)
+
+// ContextualStrategy selects arms given a feature vector describing the
+// current request, e.g. device, locale or user tenure.
+type ContextualStrategy interface {
+	Strategy
+	SelectArmWithContext(features []float64) int
+	UpdateWithContext(arm int, features []float64, reward float64)
+	Dimensions() int
+}
+
+// contextualProbabilistic strategies report selection probabilities given the
+// features of a request.
+type contextualProbabilistic interface {
+	ProbabilitiesWithContext(features []float64) []float64
+}
+
+// NewLinUCB constructs a LinUCB strategy for feature vectors of the given
+// dimension. α controls the width of the upper confidence bound.
+func NewLinUCB(arms int, α float64, dimensions int) (Strategy, error) {
+	if !(α >= 0.0) {
+		return &linUCB{}, fmt.Errorf("α not in [0, ∞)")
+	}
+
+	if dimensions < 0 {
+		return &linUCB{}, fmt.Errorf("dimensions not in [0, ∞)")
+	}
+
+	l := &linUCB{
+		Counters:   NewCounters(arms),
+		alpha:      α,
+		dimensions: dimensions,
+	}
+
+	l.Reset()
+	return l, nil
+}
+
+// linUCB is disjoint LinUCB (Li et al., 2010). Every arm keeps a ridge
+// regression of reward on the request features and a constant bias term. The
+// arm with the highest upper confidence bound on its predicted reward is
+// selected.
+type linUCB struct {
+	Counters
+	alpha      float64       // width of the confidence bound
+	dimensions int           // number of features, excluding the bias term
+	inverses   [][][]float64 // per arm inverse of A = I + Σ x·xᵀ
+	targets    [][]float64   // per arm b = Σ reward·x
+}
+
+// SelectArm selects without context. Only the bias term is used.
+func (l *linUCB) SelectArm() int {
+	return l.SelectArmWithContext(make([]float64, l.dimensions))
+}
+
+// SelectArmWithContext returns 1 indexed arm to be tried next.
+func (l *linUCB) SelectArmWithContext(features []float64) int {
+	imax := l.candidates(features)
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[l.rand.Intn(len(imax))]
+
+	l.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm without
+// context.
+func (l *linUCB) Probabilities() []float64 {
+	return l.ProbabilitiesWithContext(make([]float64, l.dimensions))
+}
+
+// ProbabilitiesWithContext returns the probability of selecting each arm
+// given the features.
+func (l *linUCB) ProbabilitiesWithContext(features []float64) []float64 {
+	return uniformOver(l.candidates(features), l.arms)
+}
+
+// candidates returns the 0 indexed arms with the highest upper confidence
+// bound given the features.
+func (l *linUCB) candidates(features []float64) []int {
+	x := l.augment(features)
+
+	ucbValues := make([]float64, l.arms)
+	for i := 0; i < l.arms; i++ {
+		θ := bmath.MatVec(l.inverses[i], l.targets[i])
+		variance := bmath.Dot(x, bmath.MatVec(l.inverses[i], x))
+		ucbValues[i] = bmath.Dot(θ, x) + l.alpha*math.Sqrt(variance)
+	}
+
+	_, imax := bmath.Max(ucbValues)
+	return imax
+}
+
+// Update without context. Only the bias term is learned.
+func (l *linUCB) Update(arm int, reward float64) {
+	l.UpdateWithContext(arm, make([]float64, l.dimensions), reward)
+}
+
+// UpdateWithContext updates the regression of the 1 indexed arm with the
+// features the arm was selected with.
+func (l *linUCB) UpdateWithContext(arm int, features []float64, reward float64) {
+	l.Counters.Update(arm, reward)
+
+	l.Lock()
+	defer l.Unlock()
+
+	arm--
+	x := l.augment(features)
+	bmath.ShermanMorrison(l.inverses[arm], x)
+	for i, xi := range x {
+		l.targets[arm][i] += reward * xi
+	}
+}
+
+// Dimensions is the number of features expected per request.
+func (l *linUCB) Dimensions() int {
+	return l.dimensions
+}
+
+// Init the strategy from a snapshot. Arms with a regression of matching
+// dimensions in the snapshot start from it. Snapshots of arms rewarded
+// without features carry no regression, so those arms are reduced to a bias
+// only regression on their mean reward.
+func (l *linUCB) Init(c *Counters) error {
+	if err := l.Counters.Init(c); err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	bias := l.dimensions
+	for i := 0; i < l.arms; i++ {
+		if c.regressions != nil && c.regressions[i] != nil && c.regressions[i].dimensions() == bias {
+			r := c.regressions[i]
+			a := bmath.Identity(bias + 1)
+			for j := range a {
+				for k := range a[j] {
+					a[j][k] += r.Gram[j][k]
+				}
+			}
+
+			inverse, ok := bmath.Inverse(a)
+			if !ok {
+				return fmt.Errorf("arm %d: singular regression", i+1)
+			}
+
+			l.inverses[i] = inverse
+			l.targets[i] = append([]float64{}, r.Moments...)
+			continue
+		}
+
+		n := float64(l.counts[i])
+		l.inverses[i] = bmath.Identity(bias + 1)
+		l.inverses[i][bias][bias] = 1 / (1 + n)
+		l.targets[i] = make([]float64, bias+1)
+		l.targets[i][bias] = n * l.values[i]
+	}
+
+	return nil
+}
+
+// addArm appends an arm. Like snapshots, the prior is a bias only regression.
+func (l *linUCB) addArm(count int, value float64) {
+	l.Counters.addArm(count, value)
+
+	l.Lock()
+	defer l.Unlock()
+
+	bias := l.dimensions
+	inverse := bmath.Identity(bias + 1)
+	inverse[bias][bias] = 1 / (1 + float64(count))
+	target := make([]float64, bias+1)
+	target[bias] = float64(count) * value
+
+	l.inverses = append(l.inverses, inverse)
+	l.targets = append(l.targets, target)
+}
+
+// removeArm removes the 1 indexed arm.
+func (l *linUCB) removeArm(arm int) {
+	l.Counters.removeArm(arm)
+
+	l.Lock()
+	defer l.Unlock()
+
+	arm--
+	l.inverses = append(append([][][]float64{}, l.inverses[:arm]...), l.inverses[arm+1:]...)
+	l.targets = append(append([][]float64{}, l.targets[:arm]...), l.targets[arm+1:]...)
+}
+
+// Reset the strategy to initial state.
+func (l *linUCB) Reset() {
+	l.Counters.Reset()
+	l.inverses = make([][][]float64, l.arms)
+	l.targets = make([][]float64, l.arms)
+	for i := 0; i < l.arms; i++ {
+		l.inverses[i] = bmath.Identity(l.dimensions + 1)
+		l.targets[i] = make([]float64, l.dimensions+1)
+	}
+}
+
+// String returns information on this strategy
+func (l *linUCB) String() string {
+	return fmt.Sprintf("LinUCB(alpha=%.2f, dimensions=%d)", l.alpha, l.dimensions)
+}
+
+// augment appends the bias term to the given features.
+func (l *linUCB) augment(features []float64) []float64 {
+	if len(features) != l.dimensions {
+		panic(fmt.Sprintf("%d features, expected %d", len(features), l.dimensions))
+	}
+
+	return append(append([]float64{}, features...), 1)
+}
@@ -2,6 +2,7 @@ package bandit
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
@@ -9,22 +10,49 @@ import (
 
 // NewCounters constructs counters for given arms
 func NewCounters(arms int) Counters {
+	return NewCountersSource(arms, rand.NewSource(time.Now().UnixNano()))
+}
+
+// NewCountersSource constructs counters for given arms which draw random
+// numbers from `src`.
+func NewCountersSource(arms int, src rand.Source) Counters {
 	return Counters{
-		arms:   arms,
-		counts: make([]int, arms),
-		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
-		values: make([]float64, arms),
+		arms:    arms,
+		counts:  make([]int, arms),
+		rand:    rand.New(src),
+		squares: make([]float64, arms),
+		values:  make([]float64, arms),
 	}
 }
 
 // Counters maintain internal strategy state
 type Counters struct {
-	sync.Mutex
+	sync.RWMutex
+
+	arms    int        // number of arms present in this strategy
+	counts  []int      // number of pulls. len(counts) == arms.
+	rand    *rand.Rand // seeded random number generator
+	squares []float64  // running average squared reward per arm. len(squares) == arms.
+	values  []float64  // running average reward per arm. len(values) == arms.
+	tags    []string   // variation tag per arm, if known. only set on snapshots.
+
+	regressions []*Regression // per arm regression on features, if known. only set on snapshots.
 
-	arms   int        // number of arms present in this strategy
-	counts []int      // number of pulls. len(counts) == arms.
-	rand   *rand.Rand // seeded random number generator
-	values []float64  // running average reward per arm. len(values) == arms.
+	generated time.Time // generation time, if known. only set on snapshots.
+	version   int       // incremented when rewards or snapshots change statistics
+}
+
+// puller strategies count pulls of arms which were selected on their behalf,
+// e.g. by sticky assignment.
+type puller interface {
+	pull(arm int)
+}
+
+// resizable strategies can add and remove arms without losing the statistics
+// of the remaining arms.
+type resizable interface {
+	addArm(count int, value float64)
+	removeArm(arm int)
 }
 
 // Update the running average, where arm is the 1 indexed arm
@@ -32,12 +60,41 @@ func (c *Counters) Update(arm int, reward float64) {
 	c.Lock()
 	defer c.Unlock()
 
+	c.version++
 	arm--
 	count := c.counts[arm]
 	c.values[arm] = ((c.values[arm] * float64(count-1)) + reward) / float64(count)
+	c.squares[arm] = ((c.squares[arm] * float64(count-1)) + reward*reward) / float64(count)
+}
+
+// Seed replaces the random source. Strategies seeded with equal sources make
+// equal selections given equal updates.
+func (c *Counters) Seed(src rand.Source) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.rand = rand.New(src)
 }
 
-// Init the strategy to a new counter state.
+// random returns the random source of the counters.
+func (c *Counters) random() *rand.Rand {
+	return c.rand
+}
+
+// state returns the version and number of arms of the counters.
+func (c *Counters) state() (int, int) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.version, c.arms
+}
+
+// variance returns the sample variance of rewards of the 0 indexed arm.
+func (c *Counters) variance(arm int) float64 {
+	return math.Max(0, c.squares[arm]-c.values[arm]*c.values[arm])
+}
+
+// Init the strategy to a new counter state. The random source is kept.
 func (c *Counters) Init(snapshot *Counters) error {
 	if c.arms != snapshot.arms {
 		return fmt.Errorf("cannot %d arms with %d arms", c.arms, snapshot.arms)
@@ -51,14 +108,98 @@ func (c *Counters) Init(snapshot *Counters) error {
 	defer c.Unlock()
 
 	c.counts = snapshot.counts
-	c.rand = snapshot.rand
+	c.squares = snapshot.squares
 	c.values = snapshot.values
+	c.version++
 
 	return nil
 }
 
+// pull counts a pull of the 1 indexed arm, as SelectArm would.
+func (c *Counters) pull(arm int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.counts[arm-1]++
+}
+
+// addArm appends an arm with prior statistics worth `count` pulls with mean
+// reward `value`.
+func (c *Counters) addArm(count int, value float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.arms++
+	c.version++
+	c.counts = append(c.counts, count)
+	c.squares = append(c.squares, value*value)
+	c.values = append(c.values, value)
+}
+
+// removeArm removes the 1 indexed arm. Later arms move down by one.
+func (c *Counters) removeArm(arm int) {
+	c.Lock()
+	defer c.Unlock()
+
+	arm--
+	c.arms--
+	c.version++
+	c.counts = append(append([]int{}, c.counts[:arm]...), c.counts[arm+1:]...)
+	c.squares = removeFloat(c.squares, arm)
+	c.values = removeFloat(c.values, arm)
+}
+
+// byTags returns the snapshot reordered to the given variation tags. Arms
+// missing from the snapshot take their statistics from `prior`.
+func (c *Counters) byTags(tags []string, prior *Counters) *Counters {
+	index := make(map[string]int)
+	for i, tag := range c.tags {
+		index[tag] = i
+	}
+
+	m := Counters{
+		arms:    len(tags),
+		counts:  make([]int, len(tags)),
+		squares: make([]float64, len(tags)),
+		values:  make([]float64, len(tags)),
+		tags:    tags,
+	}
+
+	if c.regressions != nil {
+		m.regressions = make([]*Regression, len(tags))
+	}
+
+	for i, tag := range tags {
+		source := c
+		j, ok := index[tag]
+		if !ok {
+			if i >= prior.arms {
+				continue
+			}
+
+			source, j = prior, i
+		}
+
+		m.counts[i] = source.counts[j]
+		m.squares[i] = source.squares[j]
+		m.values[i] = source.values[j]
+		if source == c && c.regressions != nil {
+			m.regressions[i] = c.regressions[j]
+		}
+	}
+
+	return &m
+}
+
 // Reset the strategy to initial state.
 func (c *Counters) Reset() {
+	c.version++
 	c.counts = make([]int, c.arms)
+	c.squares = make([]float64, c.arms)
 	c.values = make([]float64, c.arms)
 }
+
+// removeFloat returns a copy of xs without the 0 indexed element i.
+func removeFloat(xs []float64, i int) []float64 {
+	return append(append([]float64{}, xs[:i]...), xs[i+1:]...)
+}
@@ -48,9 +48,9 @@ func main() {
 
 	// routes
 	mux := pat.New()
-	mux.Get("/es/:name", bhttp.SelectionHandler(e, *exPinTTL))
+	mux.Get("/es/:name", bhttp.SelectionHandler(e, *exPinTTL, nil))
 	mux.Get("/widget", http.HandlerFunc(widget))
-	mux.Get("/feedback", bhttp.LogRewardHandler(e))
+	mux.Get("/feedback", bhttp.LogRewardHandler(e, *exPinTTL, nil))
 	mux.Get("/", http.HandlerFunc(index))
 	http.Handle("/", mux)
 
@@ -4,13 +4,18 @@
 package bandit
 
 import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
+	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,68 +35,669 @@ func NewExperiment(o Opener, name string) (*Experiment, error) {
 }
 
 // Experiment is a single experiment. Variations are in ascending ordinal
-// sorting, where ordinals are contiguous and start at 1.
+// sorting, where ordinals are contiguous and start at 1. Variation tags are
+// stable: they are not reused and do not change when other variations are
+// retired.
 type Experiment struct {
 	Name             string
 	Strategy         Strategy
 	Variations       Variations
 	PreferredOrdinal int
+	WinnerOrdinal    int      // declared winner. 0 until the stopping rule fires.
+	Prior            ArmPrior // statistics of added variations
+	Salt             string   // hashed with uids for sticky assignment
+	Audience         Rules    // requests outside the audience get the preferred variation
+	Segments         []Segment
+	State            State      // lifecycle state. Only running experiments explore.
+	Start            time.Time  // scheduled start of a draft experiment. Zero if unscheduled.
+	End              time.Time  // scheduled conclusion. Zero if unscheduled.
+	Layer            string     // experiments in a layer are mutually exclusive
+	Buckets          Buckets    // buckets of the layer claimed by this experiment
+	Allocation       float64    // fraction of users enrolled, in (0, 1]. 0 enrolls all users.
+	Ramp             []RampStep // allocations over time, in chronological order
+	Factors          []Factor   // factors of factorial experiments, nil otherwise
+	Graduation       Graduation // policy declaring the winner. the zero policy never fires.
+	Decision         *Decision  // graduation decision. nil until the experiment graduates.
+
+	mu       sync.RWMutex
+	nextID   int            // tag id of the next added variation
+	config   strategyConfig // configuration the strategy was built from
+	assigned *assignments   // sticky assignments of recently seen uids
+}
+
+// ArmPrior is the statistics a new arm starts with, worth `Count` pulls with
+// mean reward `Value`. Optimistic priors have a high value and a low count,
+// so that new arms are explored quickly. The zero prior is an untried arm.
+type ArmPrior struct {
+	Count int     `json:"count"`
+	Value float64 `json:"value"`
 }
 
-// Select calls SelectArm on the strategy and returns the associated variation
+// Select calls SelectArm on the strategy and returns the associated variation.
+// Once a winner is declared, the winning variation is returned instead.
+// Experiments which are not running return the preferred variation.
 func (e *Experiment) Select() Variation {
-	selected := e.Strategy.SelectArm()
-	if selected > len(e.Variations) {
-		panic("selected impossible arm")
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if selected, ok := e.fixed(); ok {
+		return selected.Variation
 	}
 
-	v, _ := e.GetVariation(selected)
-	return v
+	return e.variation(e.Strategy.SelectArm())
 }
 
-// SelectTimestamped selects the appropriate variation given it's
-// timestampedTag. A timestamped tag is a string in the form
-// <tag>:<timestamp>. If the duration between <timestamp> and the current time
-// is smaller than `d`, the given tagged is used to return variation. If it is
-// larger, Select() is called instead.  If the `timestampedTag` argument is
-// the blank string, Select() is called instead.
-func (e *Experiment) SelectTimestamped(
-	timestampedTag string,
-	ttl time.Duration) (Variation, string, error) {
-	now := time.Now().Unix()
+// SelectContext selects a variation given features describing the request.
+// Features are ignored if the strategy is not contextual.
+func (e *Experiment) SelectContext(features []float64) (Variation, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if selected, ok := e.fixed(); ok {
+		return selected.Variation, nil
+	}
+
+	s, err := e.contextual(features)
+	if err != nil {
+		return Variation{}, err
+	}
+
+	if s == nil {
+		return e.variation(e.Strategy.SelectArm()), nil
+	}
+
+	return e.variation(s.SelectArmWithContext(features)), nil
+}
 
-	if timestampedTag == "" {
-		selected := e.Select()
-		return selected, makeTimestampedTag(selected, now), nil
+// slate selects k distinct variations of this experiment only, in order of
+// position. Each selection's propensity is the probability that its
+// variation is part of the slate. Experiments which do not explore fill the
+// slate around their fixed selection.
+func (e *Experiment) slate(k int) ([]Selection, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if selected, ok := e.fixed(); ok {
+		return e.fill(selected, k)
 	}
 
-	tag, ts, err := TimestampedTagToTag(timestampedTag)
+	s, err := slateStrategy(e.Strategy)
 	if err != nil {
-		return Variation{}, "", fmt.Errorf("bad timestamped tag: %s", err.Error())
+		return nil, fmt.Errorf("%s: %s", e.Name, err.Error())
+	}
+
+	if l := len(e.Variations); k < 1 || k > l {
+		return nil, fmt.Errorf("k %d not in [1,%d]", k, l)
+	}
+
+	// probabilities have to be taken before selecting changes the counters
+	ps := s.SlateProbabilities(k)
+
+	var slate []Selection
+	for i, arm := range s.SelectArms(k) {
+		slate = append(slate, Selection{
+			Variation:  e.variation(arm),
+			Propensity: ps[arm-1],
+			Position:   i + 1,
+		})
+	}
+
+	return slate, nil
+}
+
+// fill returns a slate of k variations with the fixed selection on top,
+// followed by the next variations in order of ordinal, wrapping around. Each
+// variation is part of the slate with k times the probability of the fixed
+// selection, which is 1 for fixed variations and k/n for uniform ones.
+func (e *Experiment) fill(fixed Selection, k int) ([]Selection, error) {
+	n := len(e.Variations)
+	if k < 1 || k > n {
+		return nil, fmt.Errorf("k %d not in [1,%d]", k, n)
+	}
+
+	start := -1
+	for i, v := range e.Variations {
+		if v.Tag == fixed.Tag {
+			start = i
+		}
+	}
+
+	slate := make([]Selection, k)
+	for i := range slate {
+		slate[i] = fixed
+		slate[i].Propensity = math.Min(1, fixed.Propensity*float64(k))
+		slate[i].Position = i + 1
+		if i > 0 {
+			slate[i].Variation = e.Variations[(start+i)%n]
+		}
+	}
+
+	return slate, nil
+}
+
+// UpdateSlate rewards the variations of a slate returned by SelectSlate.
+// Rewards are given in order of position. Like Update, the slate is rewarded
+// in the experiment or segment its tags belong to.
+func (e *Experiment) UpdateSlate(variations []Variation, rewards []float64) error {
+	if len(variations) != len(rewards) {
+		return fmt.Errorf("%d variations, but %d rewards", len(variations), len(rewards))
+	}
+
+	if len(variations) == 0 {
+		return fmt.Errorf("cannot update empty slate")
+	}
+
+	for _, x := range e.experiments() {
+		if found, err := x.updateSlate(variations, rewards); found {
+			return err
+		}
+	}
+
+	return fmt.Errorf("tag '%s' is not in experiment %s", variations[0].Tag, e.Name)
+}
+
+// updateSlate rewards the tagged slate in this experiment only. Returns false
+// if the tag of the first variation is not in the experiment.
+func (e *Experiment) updateSlate(variations []Variation, rewards []float64) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if _, err := e.taggedVariation(variations[0].Tag); err != nil {
+		return false, nil
 	}
 
-	// return the given timestamped tag
-	if ttl > time.Since(time.Unix(ts, 0)) {
-		v, err := e.GetTaggedVariation(tag)
+	s, err := slateStrategy(e.Strategy)
+	if err != nil {
+		return true, fmt.Errorf("%s: %s", e.Name, err.Error())
+	}
 
-		// could not get tagged variation. this can occurr when switching between
-		// experiments. users still pinned to the previous experiment will see
-		// failures because the old experiment name is unknown.
+	var arms []int
+	for i, v := range variations {
+		variation, err := e.taggedVariation(v.Tag)
 		if err != nil {
-			log.Printf("repinned after error: %s", err.Error())
-			selected := e.Select()
-			return selected, makeTimestampedTag(selected, now), nil
+			return true, fmt.Errorf("%s: %s", e.Name, err.Error())
+		}
+
+		if err := checkReward(e.Strategy, rewards[i]); err != nil {
+			return true, fmt.Errorf("%s: %s", e.Name, err.Error())
+		}
+
+		arms = append(arms, variation.Ordinal)
+	}
+
+	s.UpdateSlate(arms, rewards)
+	return true, nil
+}
+
+// slateStrategy returns the strategy if it can select slates. Delayed
+// strategies can if the wrapped strategy can.
+func slateStrategy(s Strategy) (SlateStrategy, error) {
+	if d, ok := s.(*delayedStrategy); ok {
+		return d.slate()
+	}
+
+	slate, ok := s.(SlateStrategy)
+	if !ok {
+		return nil, fmt.Errorf("%s cannot select slates", s)
+	}
+
+	return slate, nil
+}
+
+// AddVariation adds a variation to a running experiment and its segments.
+// The new arm starts with the experiment's prior; all other arms keep their
+// statistics.
+func (e *Experiment) AddVariation(url, description string) (Variation, error) {
+	experiments := e.experiments()
+	for _, x := range experiments {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+
+		if _, ok := resizableStrategy(x.Strategy); !ok {
+			return Variation{}, fmt.Errorf("%s: cannot add arms to %s", x.Name, x.Strategy)
+		}
+	}
+
+	for _, s := range e.Segments {
+		s.Experiment.addVariation(url, description)
+	}
+
+	return e.addVariation(url, description), nil
+}
+
+// addVariation is AddVariation for this experiment only, without locking.
+// The strategy must be resizable.
+func (e *Experiment) addVariation(url, description string) Variation {
+	s, _ := resizableStrategy(e.Strategy)
+	v := Variation{
+		Ordinal:     len(e.Variations) + 1,
+		URL:         url,
+		Tag:         fmt.Sprintf("%s:%d", e.Name, e.nextID),
+		Description: description,
+	}
+
+	s.addArm(e.Prior.Count, e.Prior.Value)
+	e.Variations = append(e.Variations, v)
+	e.nextID++
+	e.tagged()
+
+	return v
+}
+
+// RetireVariation removes the tagged variation from a running experiment and
+// its segments. Later variations move down by one ordinal; all other arms
+// keep their statistics. Users pinned to the retired variation are repinned.
+func (e *Experiment) RetireVariation(tag string) error {
+	experiments := e.experiments()
+	for _, x := range experiments {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+
+		if _, ok := resizableStrategy(x.Strategy); !ok {
+			return fmt.Errorf("%s: cannot remove arms from %s", x.Name, x.Strategy)
+		}
+	}
+
+	if err := e.retireVariation(tag); err != nil {
+		return err
+	}
+
+	for _, s := range e.Segments {
+		if err := s.Experiment.retireVariation(e.segmentTag(s.Experiment, tag)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retireVariation is RetireVariation for this experiment only, without
+// locking. The strategy must be resizable.
+func (e *Experiment) retireVariation(tag string) error {
+	s, _ := resizableStrategy(e.Strategy)
+	v, err := e.taggedVariation(tag)
+	if err != nil {
+		return err
+	}
+
+	if v.Ordinal == e.PreferredOrdinal {
+		return fmt.Errorf("cannot retire preferred variation %s", tag)
+	}
+
+	s.removeArm(v.Ordinal)
+
+	var variations Variations
+	for _, variation := range e.Variations {
+		switch {
+		case variation.Ordinal == v.Ordinal:
+			continue
+		case variation.Ordinal > v.Ordinal:
+			variation.Ordinal--
+		}
+
+		variations = append(variations, variation)
+	}
+
+	if e.PreferredOrdinal > v.Ordinal {
+		e.PreferredOrdinal--
+	}
+
+	if e.WinnerOrdinal > v.Ordinal {
+		e.WinnerOrdinal--
+	}
+
+	e.Variations = variations
+	e.tagged()
+
+	return nil
+}
+
+// tagged tells delayed strategies the tag of each arm, so that snapshots are
+// mapped onto arms by tag.
+func (e *Experiment) tagged() {
+	d, ok := e.Strategy.(*delayedStrategy)
+	if !ok {
+		return
+	}
+
+	var tags []string
+	for _, v := range e.Variations {
+		tags = append(tags, v.Tag)
+	}
+
+	d.setTags(tags)
+}
+
+// resizableStrategy returns the strategy if arms can be added and removed.
+// Delayed strategies are resizable if the wrapped strategy is.
+func resizableStrategy(s Strategy) (resizable, bool) {
+	if d, ok := s.(*delayedStrategy); ok {
+		if _, ok := d.strategy.(resizable); !ok {
+			return nil, false
+		}
+	}
+
+	r, ok := s.(resizable)
+	return r, ok
+}
+
+// declare switches the experiment to the winning variation for good, once
+// a best arm identifying strategy is confident. The winner also becomes the
+// preferred variation, and the decision is persisted like a graduation if
+// the experiment has a decisions file, which configured experiments require.
+// Stopping rules may be expensive, e.g. the Monte Carlo estimate of top-two
+// thompson sampling, so declare is called by Graduate rather than on
+// selection, which only reads the declared winner.
+func (e *Experiment) declare(now time.Time) error {
+	s, ok := e.Strategy.(BestArmIdentifier)
+	if !ok {
+		return nil
+	}
+
+	e.mu.RLock()
+	declared := e.WinnerOrdinal > 0
+	e.mu.RUnlock()
+	if declared {
+		return nil
+	}
+
+	winner, confident := s.Done()
+	if !confident {
+		return nil
+	}
+
+	pulls := 0
+	if s, ok := e.Strategy.(snapshotter); ok {
+		for _, count := range s.Snapshot().counts {
+			pulls += count
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.WinnerOrdinal > 0 || winner > len(e.Variations) {
+		return nil // declared or resized in the meantime
+	}
+
+	decision := Decision{Winner: e.Variations[winner-1].Tag, At: now, Pulls: pulls}
+	if e.Graduation.Decisions != "" {
+		if err := saveDecision(e.Graduation.Decisions, e.Name, decision); err != nil {
+			return err
+		}
+	}
+
+	e.decide(decision)
+	return nil
+}
+
+// fixed returns the selection of experiments which do not explore. These are
+// experiments which are not running, experiments with a declared winner and
+// experiments falling back from a stale snapshot. Selections of experiments
+// which are not running are excluded.
+func (e *Experiment) fixed() (Selection, bool) {
+	if e.stateAt(time.Now()) != Running {
+		return Selection{
+			Variation:  e.variation(e.PreferredOrdinal),
+			Propensity: 1,
+			Excluded:   true,
+		}, true
+	}
+
+	if e.WinnerOrdinal > 0 {
+		return Selection{Variation: e.variation(e.WinnerOrdinal), Propensity: 1}, true
+	}
+
+	return e.fallback()
+}
+
+// selection selects a variation given features, along with the probability
+// with which it was selected.
+func (e *Experiment) selection(features []float64) (Selection, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if selected, ok := e.fixed(); ok {
+		return selected, nil
+	}
+
+	s, err := e.contextual(features)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	// probabilities have to be taken before selecting changes the counters
+	ps := e.probabilities(s, features)
+	var selected int
+	if s == nil {
+		selected = e.Strategy.SelectArm()
+	} else {
+		selected = s.SelectArmWithContext(features)
+	}
+
+	selection := Selection{Variation: e.variation(selected)}
+	if ps != nil {
+		selection.Propensity = ps[selected-1]
+	}
+
+	if s != nil {
+		selection.Features = features
+	}
+
+	return selection, nil
+}
+
+// SelectSticky assigns a user to a variation without any client state. The
+// uid is hashed together with the experiment's salt onto [0, 1), which is
+// mapped onto arms through the cumulative selection probabilities of the
+// strategy. New users follow the current allocation. Assignments of the most
+// recently seen uids are remembered, so repeat visits get the same variation
+// even if the strategy samples its probabilities. Repeat visits are marked
+// Sticky and do not count as pulls.
+func (e *Experiment) SelectSticky(uid string, features []float64) (Selection, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if selected, ok := e.fixed(); ok {
+		return selected, nil
+	}
+
+	s, err := e.contextual(features)
+	if err != nil {
+		return Selection{}, err
+	}
+
+	if s == nil {
+		features = nil
+	}
+
+	e.assigned.Lock()
+	defer e.assigned.Unlock()
+
+	if a, ok := e.assigned.get(uid); ok {
+		if variation, err := e.taggedVariation(a.tag); err == nil {
+			return Selection{
+				Variation:  variation,
+				Propensity: a.propensity,
+				Features:   features,
+				Sticky:     true,
+			}, nil
+		}
+	}
+
+	ps := e.probabilities(s, features)
+	if ps == nil {
+		return Selection{}, fmt.Errorf("%s: %s cannot assign sticky variations", e.Name, e.Strategy)
+	}
+
+	arm := draw(stickyHash(e.Salt, uid), ps)
+	if p, ok := e.Strategy.(puller); ok {
+		p.pull(arm + 1)
+	}
+
+	selected := Selection{Variation: e.variation(arm + 1), Propensity: ps[arm], Features: features}
+	e.assigned.assign(uid, selected)
+
+	return selected, nil
+}
+
+// maxAssignments is the number of sticky assignments kept per experiment.
+const maxAssignments = 100000
+
+// assignment is the variation a uid was first assigned to, and the
+// probability of that assignment.
+type assignment struct {
+	uid        string
+	tag        string
+	propensity float64
+}
+
+// assignments are the sticky assignments of the most recently seen uids, up
+// to a capacity. They are kept in memory by each process: uids evicted, or
+// first seen by another process or before a restart, are assigned by hash
+// again under the allocation at that time. Assignments of retired variations
+// are replaced on the next visit. The nil value remembers nothing.
+type assignments struct {
+	sync.Mutex
+	capacity int
+	recent   *list.List               // assignments, most recently seen first
+	uids     map[string]*list.Element // elements of recent by uid
+}
+
+// newAssignments returns an empty set of at most `capacity` assignments.
+func newAssignments(capacity int) *assignments {
+	return &assignments{
+		capacity: capacity,
+		recent:   list.New(),
+		uids:     make(map[string]*list.Element),
+	}
+}
+
+// Lock the assignments. Does nothing on nil assignments.
+func (a *assignments) Lock() {
+	if a != nil {
+		a.Mutex.Lock()
+	}
+}
+
+// Unlock the assignments. Does nothing on nil assignments.
+func (a *assignments) Unlock() {
+	if a != nil {
+		a.Mutex.Unlock()
+	}
+}
+
+// get returns the assignment of uid, and marks it as recently seen. Callers
+// hold the lock.
+func (a *assignments) get(uid string) (assignment, bool) {
+	if a == nil {
+		return assignment{}, false
+	}
+
+	el, ok := a.uids[uid]
+	if !ok {
+		return assignment{}, false
+	}
+
+	a.recent.MoveToFront(el)
+	return el.Value.(assignment), true
+}
+
+// assign records the selection of uid, evicting the least recently seen
+// assignment beyond capacity. Callers hold the lock.
+func (a *assignments) assign(uid string, s Selection) {
+	if a == nil {
+		return
+	}
+
+	if el, ok := a.uids[uid]; ok {
+		a.recent.Remove(el)
+	}
+
+	a.uids[uid] = a.recent.PushFront(assignment{uid: uid, tag: s.Tag, propensity: s.Propensity})
+	for a.recent.Len() > a.capacity {
+		oldest := a.recent.Back()
+		a.recent.Remove(oldest)
+		delete(a.uids, oldest.Value.(assignment).uid)
+	}
+}
+
+// probabilities returns the selection probabilities of the strategy, given
+// the features if it is contextual. Returns nil if the strategy does not
+// report probabilities.
+func (e *Experiment) probabilities(s ContextualStrategy, features []float64) []float64 {
+	if s != nil {
+		if p, ok := s.(contextualProbabilistic); ok {
+			return p.ProbabilitiesWithContext(features)
 		}
 
-		return v, makeTimestampedTag(v, ts), err
+		return nil
+	}
+
+	if p, ok := e.Strategy.(Probabilistic); ok {
+		return p.Probabilities()
+	}
+
+	return nil
+}
+
+// stickyHash maps the salted uid uniformly onto [0, 1).
+func stickyHash(salt, uid string) float64 {
+	sum := sha1.Sum([]byte(salt + ":" + uid))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// contextual returns the strategy as a contextual strategy, or nil if it
+// does not use features.
+func (e *Experiment) contextual(features []float64) (ContextualStrategy, error) {
+	s, ok := e.Strategy.(ContextualStrategy)
+	if !ok || s.Dimensions() == 0 {
+		return nil, nil
 	}
 
-	selected := e.Select()
-	return selected, makeTimestampedTag(selected, now), nil
+	if d := s.Dimensions(); len(features) != d {
+		return nil, fmt.Errorf("%s expects %d features, got %d", e.Name, d, len(features))
+	}
+
+	return s, nil
+}
+
+// variation returns the variation for an arm selected by the strategy.
+func (e *Experiment) variation(selected int) Variation {
+	if selected > len(e.Variations) {
+		panic("selected impossible arm")
+	}
+
+	return e.Variations[selected-1]
+}
+
+// SelectTimestamped selects the appropriate variation given it's
+// timestampedTag. A timestamped tag is a string in the form
+// <tag>:<timestamp>. If the duration between <timestamp> and the current time
+// is smaller than `d`, the given tagged is used to return variation. If it is
+// larger, Select() is called instead.  If the `timestampedTag` argument is
+// the blank string, Select() is called instead. Pinned variations are
+// returned with a propensity of 1.
+func (e *Experiment) SelectTimestamped(
+	timestampedTag string,
+	ttl time.Duration) (Selection, string, error) {
+	return e.SelectTimestampedContext(timestampedTag, nil, ttl)
+}
+
+// SelectTimestampedContext is SelectTimestamped for contextual strategies.
+// Features are used whenever a new selection is made.
+func (e *Experiment) SelectTimestampedContext(
+	timestampedTag string,
+	features []float64,
+	ttl time.Duration) (Selection, string, error) {
+	return e.SelectTimestampedRequest(timestampedTag, Request{Features: features}, ttl)
 }
 
 // GetVariation selects the appropriate variation given it's 1 indexed ordinal
 func (e *Experiment) GetVariation(ordinal int) (Variation, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	if l := len(e.Variations); ordinal < 0 || ordinal > l {
 		return Variation{}, fmt.Errorf("ordinal %d not in [1,%d]", ordinal, l)
 	}
@@ -99,8 +705,24 @@ func (e *Experiment) GetVariation(ordinal int) (Variation, error) {
 	return e.Variations[ordinal-1], nil
 }
 
-// GetTaggedVariation selects the appropriate variation given it's tag
+// GetTaggedVariation selects the appropriate variation given it's tag. Tags
+// of segments are found as well.
 func (e *Experiment) GetTaggedVariation(tag string) (Variation, error) {
+	for _, x := range e.experiments() {
+		x.mu.RLock()
+		v, err := x.taggedVariation(tag)
+		x.mu.RUnlock()
+
+		if err == nil {
+			return v, nil
+		}
+	}
+
+	return Variation{}, fmt.Errorf("tag '%s' is not in experiment %s", tag, e.Name)
+}
+
+// taggedVariation is GetTaggedVariation without locking.
+func (e *Experiment) taggedVariation(tag string) (Variation, error) {
 	for _, variation := range e.Variations {
 		if variation.Tag == tag {
 			return variation, nil
@@ -110,17 +732,64 @@ func (e *Experiment) GetTaggedVariation(tag string) (Variation, error) {
 	return Variation{}, fmt.Errorf("tag '%s' is not in experiment %s", tag, e.Name)
 }
 
-// makeTimestampedTag returns the variation tag as <tag>:<timestampNow>
-func makeTimestampedTag(v Variation, now int64) string {
-	return fmt.Sprintf("%s:%s", v.Tag, strconv.FormatInt(now, 10))
+// makeTimestampedTag returns the variation tag as <tag>:<timestampNow>,
+// followed by :p<propensity> if the propensity is known.
+func makeTimestampedTag(v Variation, now int64, propensity float64) string {
+	tag := fmt.Sprintf("%s:%s", v.Tag, strconv.FormatInt(now, 10))
+	if propensity > 0 {
+		tag += ":p" + strconv.FormatFloat(propensity, 'g', -1, 64)
+	}
+
+	return tag
 }
 
 // Variation describes endpoints which are mapped onto strategy arms.
 type Variation struct {
-	Ordinal     int    // 1 indexed arm ordinal
-	URL         string // the url associated with this variation, for out of band
-	Tag         string // this tag is used throughout the lifecycle of the experiment
-	Description string // freitext
+	Ordinal     int               // 1 indexed arm ordinal
+	URL         string            // the url associated with this variation, for out of band
+	Tag         string            // this tag is used throughout the lifecycle of the experiment
+	Description string            // freitext
+	Payload     json.RawMessage   // free-form json, e.g. colours or copy. nil if not configured.
+	Overrides   []string          // uids which always get this variation, e.g. QA
+	Levels      map[string]string // factor name -> level. nil outside factorial experiments.
+}
+
+// Decode unmarshals the payload of the variation into target.
+func (v Variation) Decode(target interface{}) error {
+	if len(v.Payload) == 0 {
+		return fmt.Errorf("variation %s has no payload", v.Tag)
+	}
+
+	if err := json.Unmarshal(v.Payload, target); err != nil {
+		return fmt.Errorf("could not decode payload of %s: %s", v.Tag, err.Error())
+	}
+
+	return nil
+}
+
+// Selection is a variation selected for a request, along with the
+// probability with which the strategy selected it.
+type Selection struct {
+	Variation
+	Propensity  float64   // 0 if the strategy does not report probabilities
+	Position    int       // 1 indexed position in a slate. 0 for single selections.
+	Excluded    bool      // outside the audience, or not running. Not logged.
+	NotEnrolled bool      // outside the allocation. Logged, but not as a selection.
+	Override    bool      // forced by an allowlist or a signed override. Logged as override.
+	Features    []float64 // features the variation was selected with. nil if not contextual.
+	Sticky      bool      // repeat visit of an assigned uid. Not pulled, not logged.
+}
+
+// Counted returns true if the selection is part of the experiment: it is
+// logged as a selection and can be rewarded.
+func (s Selection) Counted() bool {
+	return !s.Excluded && !s.NotEnrolled && !s.Override
+}
+
+// TimestampedTag returns the tag of the selection made at `now`, carrying
+// its propensity, see TimestampedTagPropensity.
+func (s Selection) TimestampedTag(now int64) string {
+	return makeTimestampedTag(s.Variation, now, s.Propensity)
 }
 
 // Variations is a set of variations sorted by ordinal.
@@ -132,30 +801,59 @@ func (v Variations) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
 
 // NewExperiments reads in a json file and converts it to a map of experiments.
 func NewExperiments(o Opener) (*Experiments, error) {
+	jsonString, err := readExperiments(o)
+	if err != nil {
+		return &Experiments{}, err
+	}
+
+	return parseExperiments(jsonString)
+}
+
+// readExperiments returns the contents of the experiments source.
+func readExperiments(o Opener) ([]byte, error) {
 	file, err := o.Open()
 	if err != nil {
-		return &Experiments{}, fmt.Errorf("need a valid input file: %v", err)
+		return nil, fmt.Errorf("need a valid input file: %v", err)
 	}
 
 	defer file.Close()
 
 	jsonString, err := ioutil.ReadAll(file)
 	if err != nil {
-		return &Experiments{}, fmt.Errorf("could not read jsony: %s", err.Error())
+		return nil, fmt.Errorf("could not read jsony: %s", err.Error())
 	}
 
+	return jsonString, nil
+}
+
+// parseExperiments converts json to a map of experiments. Strategies polling
+// snapshots are stopped if the configuration turns out to be invalid.
+func parseExperiments(jsonString []byte) (experiments *Experiments, err error) {
 	type variationConfig struct {
-		URL         string `json:"url"`
-		Description string `json:"description"`
-		Ordinal     int    `json:"ordinal"`
+		URL         string            `json:"url"`
+		Description string            `json:"description"`
+		Ordinal     int               `json:"ordinal"`
+		Payload     json.RawMessage   `json:"payload"`
+		Overrides   []string          `json:"overrides"`
+		Levels      map[string]string `json:"-"`
 	}
 
 	type experimentsConfig struct {
+		strategyConfig
 		Name             string            `json:"experiment_name"`
-		Strategy         string            `json:"strategy"`
-		Snapshot         string            `json:"snapshot"`
-		SnapshotPoll     int               `json:"snapshot-poll-seconds"`
-		Parameters       []float64         `json:"parameters"`
+		Prior            ArmPrior          `json:"arm-prior"`
+		Salt             string            `json:"salt"`
+		Audience         Rules             `json:"audience"`
+		State            State             `json:"state"`
+		Start            time.Time         `json:"start"`
+		End              time.Time         `json:"end"`
+		Layer            string            `json:"layer"`
+		Buckets          Buckets           `json:"buckets"`
+		Allocation       *float64          `json:"allocation"`
+		Ramp             []RampStep        `json:"ramp"`
+		Segments         []segmentConfig   `json:"segments"`
+		Factors          []Factor          `json:"factors"`
+		Graduation       Graduation        `json:"graduation"`
 		Variations       []variationConfig `json:"variations"`
 		PreferredOrdinal int               `json:"preferred"`
 	}
@@ -165,37 +863,120 @@ func NewExperiments(o Opener) (*Experiments, error) {
 		return &Experiments{}, fmt.Errorf("could not marshal json: %s ", err.Error())
 	}
 
-	// have to specify poll duration along with snapshot location
-	for _, c := range cfg {
-		if c.Snapshot != "" && c.SnapshotPoll == 0 {
-			return &Experiments{}, fmt.Errorf("%s is missing snapshot-poll-seconds", c.Name)
+	// validate snapshots, schedules and allocations
+	for i, c := range cfg {
+		if err := c.strategyConfig.check(); err != nil {
+			return &Experiments{}, fmt.Errorf("%s %s", c.Name, err.Error())
+		}
+
+		// experiments without a state are running
+		if c.State == "" {
+			cfg[i].State = Running
+		}
+
+		if err := checkSchedule(cfg[i].State, c.Start, c.End); err != nil {
+			return &Experiments{}, fmt.Errorf("%s: %s", c.Name, err.Error())
+		}
+
+		// experiments without allocation enroll all users
+		if c.Allocation == nil {
+			all := 1.0
+			cfg[i].Allocation = &all
+		}
+
+		if err := checkAllocation(*cfg[i].Allocation, c.Ramp); err != nil {
+			return &Experiments{}, fmt.Errorf("%s: %s", c.Name, err.Error())
+		}
+
+		if err := c.Graduation.check(); err != nil {
+			return &Experiments{}, fmt.Errorf("%s: %s", c.Name, err.Error())
+		}
+
+		// factorial experiments have one variation per combination of levels.
+		// the first combination is the control.
+		if len(c.Factors) > 0 {
+			if len(c.Variations) > 0 {
+				return &Experiments{}, fmt.Errorf("%s: cannot have both factors and variations", c.Name)
+			}
+
+			if err := checkFactors(c.Factors); err != nil {
+				return &Experiments{}, fmt.Errorf("%s: %s", c.Name, err.Error())
+			}
+
+			for j, levels := range combinations(c.Factors) {
+				cfg[i].Variations = append(cfg[i].Variations, variationConfig{
+					Ordinal:     j + 1,
+					Description: describeLevels(c.Factors, levels),
+					Levels:      levels,
+				})
+			}
+
+			if c.PreferredOrdinal == 0 {
+				cfg[i].PreferredOrdinal = 1
+			}
+		}
+
+		// winners of best arm identification are persisted like graduations
+		if c.identifies() && c.Graduation.Decisions == "" {
+			return &Experiments{}, fmt.Errorf("%s: %s needs a graduation decisions file", c.Name, c.Strategy)
+		}
+
+		for _, segment := range c.Segments {
+			if err := segment.strategyConfig.check(); err != nil {
+				return &Experiments{}, fmt.Errorf("%s@%s %s", c.Name, segment.Name, err.Error())
+			}
+
+			if segment.identifies() && c.Graduation.Decisions == "" {
+				return &Experiments{}, fmt.Errorf("%s@%s: %s needs a graduation decisions file", c.Name, segment.Name, segment.Strategy)
+			}
 		}
 	}
 
 	es := Experiments{}
+	defer func() {
+		if err != nil {
+			es.stop()
+		}
+	}()
+
 	for _, e := range cfg {
 		if e.PreferredOrdinal == 0 {
 			return &Experiments{}, fmt.Errorf("could not make strategy: preferred variation missing")
 		}
 
-		strategy, err := New(len(e.Variations), e.Strategy, e.Parameters)
+		strategy, err := e.strategyConfig.build(e.Name, len(e.Variations))
 		if err != nil {
-			return &Experiments{}, fmt.Errorf("could not make strategy: %s ", err.Error())
+			return &Experiments{}, err
 		}
 
-		// this is a delayed strategy; gets it's internal state from a snapshot
-		if e.Snapshot != "" {
-			opener := NewOpener(e.Snapshot)
-			duration := time.Duration(e.SnapshotPoll) * time.Second
-			strategy, err = NewDelayed(strategy, opener, duration)
-			if err != nil {
-				return &Experiments{}, fmt.Errorf("could not delay strategy: %s ", err.Error())
-			}
+		// changing the salt reshuffles all sticky users
+		salt := e.Salt
+		if salt == "" {
+			salt = e.Name
 		}
 
 		experiment := Experiment{
-			Name:     e.Name,
-			Strategy: strategy,
+			Name:       e.Name,
+			Strategy:   strategy,
+			Prior:      e.Prior,
+			Salt:       salt,
+			Audience:   e.Audience,
+			State:      e.State,
+			Start:      e.Start,
+			End:        e.End,
+			Layer:      e.Layer,
+			Buckets:    e.Buckets,
+			Allocation: *e.Allocation,
+			Ramp:       e.Ramp,
+			Factors:    e.Factors,
+			Graduation: e.Graduation,
+			config:     e.strategyConfig,
+			assigned:   newAssignments(maxAssignments),
+		}
+
+		// experiments claim the whole layer by default
+		if experiment.Buckets == (Buckets{}) {
+			experiment.Buckets = Buckets{0, layerBuckets}
 		}
 
 		es[e.Name] = &experiment
@@ -210,7 +991,14 @@ func NewExperiments(o Opener) (*Experiments, error) {
 				URL:         v.URL,
 				Tag:         fmt.Sprintf("%s:%d", e.Name, v.Ordinal),
 				Description: v.Description,
+				Payload:     v.Payload,
+				Overrides:   v.Overrides,
+				Levels:      v.Levels,
 			})
+
+			if v.Ordinal >= experiment.nextID {
+				experiment.nextID = v.Ordinal + 1
+			}
 		}
 
 		if experiment.PreferredOrdinal == 0 {
@@ -218,30 +1006,168 @@ func NewExperiments(o Opener) (*Experiments, error) {
 		}
 
 		sort.Sort(experiment.Variations)
+		experiment.tagged()
+
+		for _, c := range e.Segments {
+			// segments inherit the experiment's strategy, but not its snapshot.
+			// bundles are shared, segments have their own snapshot in them.
+			sc := c.strategyConfig
+			if sc.Strategy == "" {
+				sc = e.strategyConfig
+				sc.Snapshot = c.Snapshot
+				if c.Snapshot != "" || c.Bundle != "" {
+					sc.Bundle, sc.SnapshotPoll = c.Bundle, c.SnapshotPoll
+				} else if sc.Bundle == "" {
+					sc.SnapshotPoll = 0
+				}
+			}
+
+			name := fmt.Sprintf("%s@%s", e.Name, c.Name)
+			strategy, err := sc.build(name, len(e.Variations))
+			if err != nil {
+				return &Experiments{}, err
+			}
+
+			experiment.Segments = append(experiment.Segments, Segment{
+				Name:       c.Name,
+				Rules:      c.Rules,
+				Experiment: experiment.segment(name, sc, strategy),
+			})
+		}
+
+		// graduated experiments and segments keep serving their winner
+		if e.Graduation.Decisions != "" {
+			decisions, err := readDecisions(e.Graduation.Decisions)
+			if err != nil {
+				return &Experiments{}, fmt.Errorf("%s: %s", e.Name, err.Error())
+			}
+
+			for _, x := range experiment.experiments() {
+				if d, ok := decisions[x.Name]; ok {
+					x.decide(d)
+				}
+			}
+		}
+	}
+
+	if err := es.checkLayers(); err != nil {
+		return &Experiments{}, err
 	}
 
 	return &es, nil
 }
 
+// strategyConfig configures the strategy of an experiment or of a segment.
+type strategyConfig struct {
+	Strategy     string    `json:"strategy"`
+	Parameters   []float64 `json:"parameters"`
+	RewardModel  string    `json:"reward-model"`
+	Seed         *int64    `json:"seed"`
+	Snapshot     string    `json:"snapshot"`
+	Bundle       string    `json:"bundle"` // bundle with a snapshot keyed by the experiment's name
+	SnapshotPoll int       `json:"snapshot-poll-seconds"`
+	Staleness
+}
+
+// check returns an error if the snapshot configuration is invalid.
+func (c strategyConfig) check() error {
+	if c.Snapshot != "" && c.Bundle != "" {
+		return fmt.Errorf("cannot have both snapshot and bundle")
+	}
+
+	// have to specify poll duration along with snapshot location
+	if (c.Snapshot != "" || c.Bundle != "") && c.SnapshotPoll == 0 {
+		return fmt.Errorf("is missing snapshot-poll-seconds")
+	}
+
+	if c.Staleness.enabled() && c.Snapshot == "" && c.Bundle == "" {
+		return fmt.Errorf("has a staleness policy, but no snapshot or bundle")
+	}
+
+	return c.Staleness.check()
+}
+
+// identifies returns true if the strategy identifies the best arm, and
+// declares it the winner once it is confident.
+func (c strategyConfig) identifies() bool {
+	switch c.Strategy {
+	case "successiveElimination", "lucb", "topTwoThompson":
+		return true
+	}
+
+	return false
+}
+
+// build returns the configured strategy for experiment `name`.
+func (c strategyConfig) build(name string, arms int) (Strategy, error) {
+	strategy, err := New(arms, c.Strategy, c.Parameters)
+	if err != nil {
+		return &epsilonGreedy{}, fmt.Errorf("could not make strategy: %s ", err.Error())
+	}
+
+	// thompson sampling for non bernoulli rewards
+	if c.RewardModel != "" {
+		if c.Strategy != "thompson" {
+			return &epsilonGreedy{}, fmt.Errorf("%s: reward-model needs thompson strategy", name)
+		}
+
+		model := RewardModel(c.RewardModel)
+		strategy, err = NewThompsonModel(arms, c.Parameters[0], model)
+		if err != nil {
+			return &epsilonGreedy{}, fmt.Errorf("could not make strategy: %s ", err.Error())
+		}
+	}
+
+	// reproducible selections
+	if c.Seed != nil {
+		s, ok := strategy.(Seedable)
+		if !ok {
+			return &epsilonGreedy{}, fmt.Errorf("%s: %s cannot be seeded", name, strategy)
+		}
+
+		s.Seed(rand.NewSource(*c.Seed))
+	}
+
+	// this is a delayed strategy; gets it's internal state from a bundle
+	if c.Bundle != "" {
+		duration := time.Duration(c.SnapshotPoll) * time.Second
+		strategy, err = NewBundled(strategy, c.Bundle, name, duration)
+		if err != nil {
+			return &epsilonGreedy{}, fmt.Errorf("could not delay strategy: %s ", err.Error())
+		}
+	}
+
+	// this is a delayed strategy; gets it's internal state from a snapshot
+	if c.Snapshot != "" {
+		opener := NewOpener(c.Snapshot)
+		duration := time.Duration(c.SnapshotPoll) * time.Second
+		strategy, err = NewDelayed(strategy, opener, duration)
+		if err != nil {
+			return &epsilonGreedy{}, fmt.Errorf("could not delay strategy: %s ", err.Error())
+		}
+	}
+
+	return strategy, nil
+}
+
 // Experiments is an index of names to experiment
 type Experiments map[string]*Experiment
 
 // GetVariation returns the Experiment and variation pointed to by a string tag.
-func (e *Experiments) GetVariation(tag string) (Experiment, Variation, error) {
+func (e *Experiments) GetVariation(tag string) (*Experiment, Variation, error) {
 	for _, experiment := range *e {
-		for _, variation := range experiment.Variations {
-			if variation.Tag == tag {
-				return *experiment, variation, nil
-			}
+		if variation, err := experiment.GetTaggedVariation(tag); err == nil {
+			return experiment, variation, nil
 		}
 	}
 
-	return Experiment{}, Variation{}, fmt.Errorf("could not find variation '%s'", tag)
+	return &Experiment{}, Variation{}, fmt.Errorf("could not find variation '%s'", tag)
 }
 
 // TimestampedTagToTag docodes a timestamped tag in the form <tag>:<timestamp> into
-// a (tag, ts)
+// a (tag, ts). The propensity of the tag, if any, is ignored.
 func TimestampedTagToTag(timestampedTag string) (string, int64, error) {
+	timestampedTag, _ = splitPropensity(timestampedTag)
 	sep := strings.LastIndex(timestampedTag, ":")
 	if sep == -1 {
 		return "", 0, fmt.Errorf("invalid timestampedTag, does not end in :<timestamp>")
@@ -255,3 +1181,32 @@ func TimestampedTagToTag(timestampedTag string) (string, int64, error) {
 
 	return tag, ts, nil
 }
+
+// TimestampedTagPropensity returns the propensity carried by a timestamped
+// tag in the form <tag>:<timestamp>:p<propensity>, or 0 if it carries none.
+// Signed tags carry the propensity logged by the server, which clients
+// cannot change.
+func TimestampedTagPropensity(timestampedTag string) (float64, error) {
+	_, p := splitPropensity(timestampedTag)
+	if p == "" {
+		return 0, nil
+	}
+
+	propensity, err := strconv.ParseFloat(p, 64)
+	if err != nil || !(propensity > 0 && propensity <= 1) {
+		return 0, fmt.Errorf("invalid propensity '%s'", p)
+	}
+
+	return propensity, nil
+}
+
+// splitPropensity splits a timestamped tag into the tag with its timestamp
+// and the propensity, which is blank if missing.
+func splitPropensity(timestampedTag string) (string, string) {
+	sep := strings.LastIndex(timestampedTag, ":p")
+	if sep == -1 || strings.Contains(timestampedTag[sep+1:], ":") {
+		return timestampedTag, ""
+	}
+
+	return timestampedTag[:sep], timestampedTag[sep+2:]
+}
@@ -5,6 +5,8 @@ package bandit
 
 import (
 	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
 	"strings"
 	"testing"
 	"time"
@@ -35,6 +37,567 @@ func TestExperiment(t *testing.T) {
 	if got := e.PreferredOrdinal; got != expectedPreferredOrdinal {
 		t.Fatalf("expected preferred ordinal %d, got %d", expectedPreferredOrdinal, got)
 	}
+
+	var payload struct {
+		Shape string `json:"shape"`
+		Size  int    `json:"size"`
+	}
+
+	if err := e.Variations[0].Decode(&payload); err != nil {
+		t.Fatalf("could not decode payload: %s", err.Error())
+	}
+
+	if payload.Shape != "circle" || payload.Size != 12 {
+		t.Fatalf("expected circle of size 12, got %v", payload)
+	}
+
+	if err := e.Variations[1].Decode(&payload); err == nil {
+		t.Fatalf("expected variation without payload not to decode")
+	}
+}
+
+func TestExperimentSeed(t *testing.T) {
+	var selections [2][]int
+	for i := range selections {
+		es, err := NewExperiments(NewFileOpener("experiments.json"))
+		if err != nil {
+			t.Fatalf("while reading experiment fixture: %s", err.Error())
+		}
+
+		e := (*es)["shape-20130822"]
+		for j := 0; j < 100; j++ {
+			selections[i] = append(selections[i], e.Select().Ordinal)
+		}
+	}
+
+	for j := range selections[0] {
+		if selections[0][j] != selections[1][j] {
+			t.Fatalf("seeded experiments differ at selection %d", j)
+		}
+	}
+}
+
+func TestExperimentAddRetireVariation(t *testing.T) {
+	es, err := NewExperiments(NewFileOpener("experiments.json"))
+	if err != nil {
+		t.Fatalf("while reading experiment fixture: %s", err.Error())
+	}
+
+	e := (*es)["shape-20130822"]
+	e.Prior = ArmPrior{Count: 10, Value: 0.9}
+	e.Strategy.Update(e.Select().Ordinal, 1)
+
+	v, err := e.AddVariation("http://localhost:8080/widget?shape=hexagon", "Hexagons")
+	if err != nil {
+		t.Fatalf("could not add variation: %s", err.Error())
+	}
+
+	if expected := "shape-20130822:3"; v.Tag != expected || v.Ordinal != 3 {
+		t.Fatalf("expected %s at ordinal 3, got %s at %d", expected, v.Tag, v.Ordinal)
+	}
+
+	if err := e.RetireVariation("shape-20130822:2"); err == nil {
+		t.Fatalf("retired the preferred variation")
+	}
+
+	if err := e.RetireVariation("shape-20130822:1"); err != nil {
+		t.Fatalf("could not retire variation: %s", err.Error())
+	}
+
+	if got := e.Variations[1]; got.Tag != v.Tag || got.Ordinal != 2 {
+		t.Fatalf("expected %s at ordinal 2, got %s at %d", v.Tag, got.Tag, got.Ordinal)
+	}
+
+	if got := e.PreferredOrdinal; got != 1 {
+		t.Fatalf("expected preferred ordinal 1, got %d", got)
+	}
+
+	if got := e.Strategy.(*softmax).values[1]; got != 0.9 {
+		t.Fatalf("expected added arm to keep its prior, got %f", got)
+	}
+
+	v, err = e.AddVariation("http://localhost:8080/widget?shape=star", "Stars")
+	if err != nil {
+		t.Fatalf("could not add variation: %s", err.Error())
+	}
+
+	if expected := "shape-20130822:4"; v.Tag != expected {
+		t.Fatalf("expected retired tags not to be reused, got %s", v.Tag)
+	}
+}
+
+func TestExperimentSelectSlate(t *testing.T) {
+	strategy, err := NewThompson(3, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := Experiment{
+		Name:             "shape",
+		Strategy:         strategy,
+		PreferredOrdinal: 2,
+		Variations: Variations{
+			{Ordinal: 1, Tag: "shape:1"},
+			{Ordinal: 2, Tag: "shape:2"},
+			{Ordinal: 3, Tag: "shape:3"},
+		},
+	}
+
+	slate, err := e.SelectSlate(Request{}, 2)
+	if err != nil {
+		t.Fatalf("could not select slate: %s", err.Error())
+	}
+
+	if len(slate) != 2 || slate[0].Ordinal == slate[1].Ordinal {
+		t.Fatalf("expected 2 distinct variations, got %v", slate)
+	}
+
+	for i, s := range slate {
+		if s.Position != i+1 || s.Propensity <= 0 || s.Propensity >= 1 {
+			t.Fatalf("expected position %d with propensity in (0, 1), got %v", i+1, s)
+		}
+	}
+
+	variations := []Variation{slate[0].Variation, slate[1].Variation}
+	if err := e.UpdateSlate(variations, []float64{1, 0}); err != nil {
+		t.Fatalf("could not update slate: %s", err.Error())
+	}
+
+	if err := e.UpdateSlate(variations, []float64{2, 0}); err == nil {
+		t.Fatalf("expected reward out of range to be rejected")
+	}
+
+	if _, err := e.SelectSlate(Request{}, 4); err == nil {
+		t.Fatalf("selected more variations than there are")
+	}
+
+	e.Audience = Rules{"country": []string{"de"}}
+	slate, err = e.SelectSlate(Request{}, 2)
+	if err != nil {
+		t.Fatalf("could not select slate: %s", err.Error())
+	}
+
+	if !slate[0].Excluded || slate[0].Ordinal != 2 || slate[1].Ordinal != 3 {
+		t.Fatalf("expected excluded slate of the preferred variation, got %v", slate)
+	}
+
+	e.Audience = nil
+	e.WinnerOrdinal = 3
+	slate, err = e.SelectSlate(Request{}, 3)
+	if err != nil {
+		t.Fatalf("could not select slate: %s", err.Error())
+	}
+
+	for i, ordinal := range []int{3, 1, 2} {
+		if slate[i].Ordinal != ordinal || slate[i].Propensity != 1 || !slate[i].Counted() {
+			t.Fatalf("expected winner on top, got %v", slate)
+		}
+	}
+}
+
+func TestExperimentWinner(t *testing.T) {
+	strategy, err := NewSuccessiveElimination(2, 0.05)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := Experiment{
+		Name:             "shape",
+		Strategy:         strategy,
+		PreferredOrdinal: 1,
+		Variations: Variations{
+			{Ordinal: 1, Tag: "shape:1"},
+			{Ordinal: 2, Tag: "shape:2"},
+		},
+	}
+
+	for i := 0; i < 1000 && e.WinnerOrdinal == 0; i++ {
+		v := e.Select()
+		e.Strategy.Update(v.Ordinal, float64(v.Ordinal-1))
+		if err := e.Graduate(); err != nil {
+			t.Fatalf("could not graduate: %s", err.Error())
+		}
+	}
+
+	if got := e.WinnerOrdinal; got != 2 {
+		t.Fatalf("expected variation 2 to win, got %d", got)
+	}
+
+	if got := e.PreferredOrdinal; got != 2 {
+		t.Fatalf("expected the winner to be preferred, got %d", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		if got := e.Select().Ordinal; got != 2 {
+			t.Fatalf("selected %d after variation 2 won", got)
+		}
+	}
+}
+
+func TestExperimentSticky(t *testing.T) {
+	strategy, err := NewEpsilonGreedy(2, 0.1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := Experiment{
+		Name:     "shape",
+		Strategy: strategy,
+		Salt:     "shape",
+		Variations: Variations{
+			{Ordinal: 1, Tag: "shape:1"},
+			{Ordinal: 2, Tag: "shape:2"},
+		},
+		assigned: newAssignments(maxAssignments),
+	}
+
+	selected := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		for _, uid := range []string{"11", "12", "13", "14", "15", "16"} {
+			s, err := e.SelectSticky(uid, nil)
+			if err != nil {
+				t.Fatalf("could not select sticky variation: %s", err.Error())
+			}
+
+			if ordinal, ok := selected[uid]; ok && ordinal != s.Ordinal {
+				t.Fatalf("uid %s moved from %d to %d", uid, ordinal, s.Ordinal)
+			}
+
+			if s.Sticky != (i > 0) {
+				t.Fatalf("expected only repeat visits to be sticky, got %v on visit %d", s.Sticky, i)
+			}
+
+			selected[uid] = s.Ordinal
+		}
+	}
+
+	ordinals := make(map[int]bool)
+	for _, ordinal := range selected {
+		ordinals[ordinal] = true
+	}
+
+	if len(ordinals) != 2 {
+		t.Fatalf("expected uids to be spread over both variations, got %v", selected)
+	}
+
+	if got := strategy.(*epsilonGreedy).counts[0] + strategy.(*epsilonGreedy).counts[1]; got != 6 {
+		t.Fatalf("expected 6 pulls on first assignment, got %d", got)
+	}
+
+	// the least recently seen uid is evicted beyond capacity
+	a := newAssignments(2)
+	for _, uid := range []string{"11", "12", "11", "13"} {
+		if _, ok := a.get(uid); !ok {
+			a.assign(uid, Selection{Variation: Variation{Tag: "shape:1"}})
+		}
+	}
+
+	if _, ok := a.get("12"); ok {
+		t.Fatalf("expected uid 12 to be evicted")
+	}
+
+	if _, ok := a.get("11"); !ok {
+		t.Fatalf("expected uid 11 to be kept")
+	}
+}
+
+func TestExperimentStickyThompson(t *testing.T) {
+	strategy, err := NewThompson(2, 0.1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := Experiment{
+		Name:     "shape",
+		Strategy: strategy,
+		Salt:     "shape",
+		Variations: Variations{
+			{Ordinal: 1, Tag: "shape:1"},
+			{Ordinal: 2, Tag: "shape:2"},
+		},
+		assigned: newAssignments(maxAssignments),
+	}
+
+	selected := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		uid := fmt.Sprintf("%d", i)
+		s, err := e.SelectSticky(uid, nil)
+		if err != nil {
+			t.Fatalf("could not select sticky variation: %s", err.Error())
+		}
+
+		selected[uid] = s.Ordinal
+		if err := e.Update(s.Variation, 1); err != nil {
+			t.Fatalf("could not update: %s", err.Error())
+		}
+	}
+
+	for uid, ordinal := range selected {
+		s, err := e.SelectSticky(uid, nil)
+		if err != nil {
+			t.Fatalf("could not select sticky variation: %s", err.Error())
+		}
+
+		if s.Ordinal != ordinal {
+			t.Fatalf("uid %s moved from %d to %d", uid, ordinal, s.Ordinal)
+		}
+	}
+
+	if got := strategy.(*thompson).counts[0] + strategy.(*thompson).counts[1]; got != 1000 {
+		t.Fatalf("expected 1000 pulls, got %d", got)
+	}
+}
+
+func TestExperimentSegments(t *testing.T) {
+	config := stringOpener(`[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"preferred": 2,
+		"audience": {"country": ["de", "at"]},
+		"segments": [{"name": "de", "rules": {"country": ["de"]}}],
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`)
+
+	e, err := NewExperiment(config, "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	excluded, err := e.SelectRequest(Request{Attributes: map[string]string{"country": "us"}})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if !excluded.Excluded || excluded.Ordinal != 2 || excluded.Propensity != 1 {
+		t.Fatalf("expected excluded preferred variation, got %v", excluded)
+	}
+
+	at, err := e.SelectRequest(Request{Attributes: map[string]string{"country": "at"}})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if at.Excluded || strings.Index(at.Tag, "shape:") != 0 {
+		t.Fatalf("expected variation of shape, got %s", at.Tag)
+	}
+
+	de, err := e.SelectRequest(Request{Attributes: map[string]string{"country": "de"}})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if strings.Index(de.Tag, "shape@de:") != 0 {
+		t.Fatalf("expected variation of segment de, got %s", de.Tag)
+	}
+
+	if err := e.Update(de.Variation, 1); err != nil {
+		t.Fatalf("could not reward segment: %s", err.Error())
+	}
+
+	segment := e.Segments[0].Experiment.Strategy.(*epsilonGreedy)
+	if got := segment.values[de.Ordinal-1]; got != 1 {
+		t.Fatalf("expected segment to be rewarded, got %f", got)
+	}
+
+	if got := e.Strategy.(*epsilonGreedy).values[de.Ordinal-1]; got != 0 {
+		t.Fatalf("expected experiment not to be rewarded, got %f", got)
+	}
+
+	if _, err := e.AddVariation("hexagon", "Hexagons"); err != nil {
+		t.Fatalf("could not add variation: %s", err.Error())
+	}
+
+	v, err := e.GetTaggedVariation("shape@de:3")
+	if err != nil || v.URL != "hexagon" {
+		t.Fatalf("expected segment to have added variation: %v", err)
+	}
+}
+
+func TestExperimentRewardRange(t *testing.T) {
+	config := `[{
+		"experiment_name": "shape",
+		"strategy": "%s",
+		"parameters": %s,
+		"preferred": 1,
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`
+
+	strategies := []struct {
+		name       string
+		parameters string
+	}{
+		{"thompson", "[1]"},
+		{"ucb1Tuned", "[]"},
+		{"ucbV", "[1.2]"},
+		{"exp3", "[0.1]"},
+		{"exp3p", "[0.1, 0.1, 100]"},
+	}
+
+	for _, strategy := range strategies {
+		e, err := NewExperiment(stringOpener(fmt.Sprintf(config, strategy.name, strategy.parameters)), "shape")
+		if err != nil {
+			t.Fatalf("could not make experiment: %s", err.Error())
+		}
+
+		v := e.Select()
+		for _, reward := range []float64{-1, 1e9} {
+			if err := e.Update(v, reward); err == nil {
+				t.Fatalf("expected %s reward %f to be rejected", strategy.name, reward)
+			}
+		}
+
+		if err := e.Update(v, 1); err != nil {
+			t.Fatalf("could not reward: %s", err.Error())
+		}
+	}
+}
+
+func TestExperimentUpdateWithPropensity(t *testing.T) {
+	strategy, err := NewEXP3(2, 0.1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	e := Experiment{
+		Name:     "shape",
+		Strategy: strategy,
+		Variations: Variations{
+			{Ordinal: 1, Tag: "shape:1"},
+			{Ordinal: 2, Tag: "shape:2"},
+		},
+	}
+
+	// the reward of the second arm moves the probabilities of the first
+	strategy.(puller).pull(1)
+	strategy.(puller).pull(2)
+	e.Update(e.Variations[1], 1)
+	if err := e.UpdateWithPropensity(e.Variations[0], nil, 0.5, 1); err != nil {
+		t.Fatalf("could not reward: %s", err.Error())
+	}
+
+	if got, expected := strategy.(*exp3).logWeights[0], 0.1*(1/0.5)/2; math.Abs(got-expected) > 1e-12 {
+		t.Fatalf("expected reward weighted by the logged propensity, got weight %f, expected %f", got, expected)
+	}
+
+	if err := e.UpdateWithPropensity(e.Variations[0], nil, 2, 1); err == nil {
+		t.Fatalf("expected propensity outside [0, 1] to be rejected")
+	}
+
+	// tiny propensities are clamped to γ/K
+	if err := e.UpdateWithPropensity(e.Variations[0], nil, 1e-300, 1); err != nil {
+		t.Fatalf("could not reward: %s", err.Error())
+	}
+
+	for _, p := range strategy.(Probabilistic).Probabilities() {
+		if math.IsNaN(p) || p < 0.1/2 {
+			t.Fatalf("expected probabilities of at least γ/K, got %v", strategy.(Probabilistic).Probabilities())
+		}
+	}
+}
+
+func TestExperimentUpdateWithContext(t *testing.T) {
+	config := stringOpener(`[{
+		"experiment_name": "shape",
+		"strategy": "linucb",
+		"parameters": [0, 2],
+		"preferred": 1,
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`)
+
+	e, err := NewExperiment(config, "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	features := []float64{1, 0}
+	s, err := e.SelectRequest(Request{Features: features})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if line := SelectionLine(e, s); !strings.HasSuffix(line, " features=1,0") {
+		t.Fatalf("expected features to be logged, got '%s'", line)
+	}
+
+	if err := e.UpdateWithContext(s.Variation, []float64{1}, 1); err == nil {
+		t.Fatalf("expected features of the wrong dimension to be rejected")
+	}
+
+	if err := e.UpdateWithContext(s.Variation, features, 1); err != nil {
+		t.Fatalf("could not reward: %s", err.Error())
+	}
+
+	l := e.Strategy.(*linUCB)
+	θ := bmath.MatVec(l.inverses[s.Ordinal-1], l.targets[s.Ordinal-1])
+	if θ[0] <= 0 || θ[1] != 0 {
+		t.Fatalf("expected reward to be learned on the first feature, got %v", θ)
+	}
+}
+
+func TestExperimentLifecycle(t *testing.T) {
+	config := `[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [1],
+		"preferred": 2,
+		%s
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`
+
+	e, err := NewExperiment(stringOpener(fmt.Sprintf(config, `"state": "paused",`)), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	for i := 0; i < 20; i++ {
+		if got := e.Select().Ordinal; got != 2 {
+			t.Fatalf("expected paused experiment to serve preferred variation, got %d", got)
+		}
+	}
+
+	if err := e.Transition(Draft); err == nil {
+		t.Fatalf("expected paused experiment not to become a draft")
+	}
+
+	if err := e.Transition(Running); err != nil {
+		t.Fatalf("could not resume experiment: %s", err.Error())
+	}
+
+	if got := e.Status(); got != Running {
+		t.Fatalf("expected running experiment, got %s", got)
+	}
+
+	scheduled := `"state": "draft", "start": "2013-08-22T00:00:00Z", "end": "2013-09-01T00:00:00Z",`
+	e, err = NewExperiment(stringOpener(fmt.Sprintf(config, scheduled)), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	if got := e.Status(); got != Concluded {
+		t.Fatalf("expected concluded experiment, got %s", got)
+	}
+
+	if err := e.Transition(Running); err == nil {
+		t.Fatalf("expected concluded experiment not to restart")
+	}
+
+	invalid := `"state": "concluded", "end": "2013-09-01T00:00:00Z",`
+	if _, err := NewExperiment(stringOpener(fmt.Sprintf(config, invalid)), "shape"); err == nil {
+		t.Fatalf("expected concluded experiment not to be scheduled")
+	}
 }
 
 func TestTimestampedTagToTag(t *testing.T) {
@@ -50,6 +613,20 @@ func TestTimestampedTagToTag(t *testing.T) {
 	if expected := int64(1378823906); ts != expected {
 		t.Fatalf("expected %d but got %d", expected, ts)
 	}
+
+	selected := Selection{Variation: Variation{Tag: "shape-20130822:c8-circle"}, Propensity: 0.25}
+	timestampedTag := selected.TimestampedTag(1378823906)
+	if tag, ts, err := TimestampedTagToTag(timestampedTag); err != nil || tag != selected.Tag || ts != 1378823906 {
+		t.Fatalf("could not parse tag with propensity '%s'", timestampedTag)
+	}
+
+	if p, err := TimestampedTagPropensity(timestampedTag); err != nil || p != 0.25 {
+		t.Fatalf("expected propensity 0.25 in '%s', got %f", timestampedTag, p)
+	}
+
+	if p, err := TimestampedTagPropensity("shape-20130822:c8-circle:1378823906"); err != nil || p != 0 {
+		t.Fatalf("expected unknown propensity, got %f", p)
+	}
 }
 
 func TestExperimentCutoverUnexpiredTag(t *testing.T) {
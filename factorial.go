@@ -0,0 +1,202 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Factor is a dimension of a factorial experiment, e.g. the headline, with
+// the levels it is tested at. The first level is the baseline.
+type Factor struct {
+	Name   string   `json:"name"`
+	Levels []string `json:"levels"`
+}
+
+// combinations returns one map of factor name to level per combination of
+// levels. The last factor varies fastest.
+func combinations(factors []Factor) []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, factor := range factors {
+		var expanded []map[string]string
+		for _, combination := range combinations {
+			for _, level := range factor.Levels {
+				levels := map[string]string{factor.Name: level}
+				for name, l := range combination {
+					levels[name] = l
+				}
+
+				expanded = append(expanded, levels)
+			}
+		}
+
+		combinations = expanded
+	}
+
+	return combinations
+}
+
+// checkFactors returns an error if factors are unnamed, duplicated, or have
+// less than two levels.
+func checkFactors(factors []Factor) error {
+	seen := make(map[string]bool)
+	for _, factor := range factors {
+		if factor.Name == "" || seen[factor.Name] {
+			return fmt.Errorf("factor names must be unique and not blank")
+		}
+
+		if len(factor.Levels) < 2 {
+			return fmt.Errorf("factor %s needs at least 2 levels", factor.Name)
+		}
+
+		seen[factor.Name] = true
+	}
+
+	return nil
+}
+
+// describeLevels describes a combination of levels in order of factors,
+// e.g. "headline=Save, color=red".
+func describeLevels(factors []Factor, levels map[string]string) string {
+	var described []string
+	for _, factor := range factors {
+		described = append(described, fmt.Sprintf("%s=%s", factor.Name, levels[factor.Name]))
+	}
+
+	return strings.Join(described, ", ")
+}
+
+// Effect is the estimated effect of levels on the mean reward, relative to
+// the baseline levels. Main effects have one factor, interactions two.
+type Effect struct {
+	Factors  []string `json:"factors"`
+	Levels   []string `json:"levels"`   // level of each factor compared to its baseline
+	Estimate float64  `json:"estimate"` // difference in mean reward
+	StdErr   float64  `json:"std-err"`
+}
+
+// AnalyzeFactors estimates the main effect of each level and the interaction
+// of each pair of levels of different factors, relative to the baselines.
+// Cells are weighted equally, so that effects are averaged over the levels of
+// all other factors. Variations without pulls are ignored. Effects which
+// cannot be estimated, because some group of cells has no pulls, are left
+// out.
+func (e *Experiment) AnalyzeFactors() ([]Effect, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.Factors) == 0 {
+		return nil, fmt.Errorf("%s is not a factorial experiment", e.Name)
+	}
+
+	s, ok := e.Strategy.(snapshotter)
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot analyze %s", e.Name, e.Strategy)
+	}
+
+	c := s.Snapshot()
+
+	var effects []Effect
+	for i, f := range e.Factors {
+		for _, level := range f.Levels[1:] {
+			effect, ok := e.contrast(c, map[string]string{f.Name: level})
+			if !ok {
+				continue
+			}
+
+			effect.Factors, effect.Levels = []string{f.Name}, []string{level}
+			effects = append(effects, effect)
+		}
+
+		for _, g := range e.Factors[i+1:] {
+			for _, a := range f.Levels[1:] {
+				for _, b := range g.Levels[1:] {
+					effect, ok := e.contrast(c, map[string]string{f.Name: a, g.Name: b})
+					if !ok {
+						continue
+					}
+
+					effect.Factors, effect.Levels = []string{f.Name, g.Name}, []string{a, b}
+					effects = append(effects, effect)
+				}
+			}
+		}
+	}
+
+	return effects, nil
+}
+
+// contrast estimates the effect of the given levels. Each combination of
+// the given factors at either the given level or the baseline forms a group
+// of cells, which enters the contrast with sign (-1)^(number of baselines).
+// This is the difference of two levels for one factor, and the difference
+// of differences for two. Returns false if some group has no pulls, in which
+// case the effect cannot be estimated.
+func (e *Experiment) contrast(c *Counters, levels map[string]string) (Effect, bool) {
+	baselines := make(map[string]string)
+	for _, f := range e.Factors {
+		if _, ok := levels[f.Name]; ok {
+			baselines[f.Name] = f.Levels[0]
+		}
+	}
+
+	groups := make(map[string][]int) // signature of levels -> 0 indexed arms
+	signs := make(map[string]float64)
+	for i, v := range e.Variations {
+		if c.counts[i] == 0 {
+			continue
+		}
+
+		sign, signature := 1.0, []string{}
+		for name, level := range levels {
+			switch v.Levels[name] {
+			case level:
+				signature = append(signature, name+"="+level)
+			case baselines[name]:
+				signature = append(signature, name+"=")
+				sign = -sign
+			}
+		}
+
+		if len(signature) != len(levels) {
+			continue // the variation is at neither level of some factor
+		}
+
+		sort.Strings(signature)
+		key := strings.Join(signature, ",")
+		groups[key] = append(groups[key], i)
+		signs[key] = sign
+	}
+
+	var effect Effect
+	if len(groups) != 1<<uint(len(levels)) {
+		return effect, false
+	}
+
+	variance := 0.0
+	for key, arms := range groups {
+		weight := signs[key] / float64(len(arms))
+		for _, arm := range arms {
+			effect.Estimate += weight * c.values[arm]
+			variance += weight * weight * cellVariance(c, arm) / float64(c.counts[arm])
+		}
+	}
+
+	effect.StdErr = math.Sqrt(variance)
+	return effect, true
+}
+
+// cellVariance returns the variance of rewards of the 0 indexed arm. Counters
+// of snapshots do not track squared rewards, in which case rewards are
+// assumed to be Bernoulli.
+func cellVariance(c *Counters, arm int) float64 {
+	if c.squares[arm] == 0 {
+		return c.values[arm] * (1 - c.values[arm])
+	}
+
+	return c.variance(arm)
+}
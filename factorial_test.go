@@ -0,0 +1,127 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFactorial(t *testing.T) {
+	config := `[{
+		"experiment_name": "signup",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"factors": [
+			{"name": "color", "levels": ["blue", "red"]},
+			{"name": "headline", "levels": ["save", "buy"]}
+		]
+	}]`
+
+	e, err := NewExperiment(stringOpener(config), "signup")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	if got := len(e.Variations); got != 4 {
+		t.Fatalf("expected 4 combinations, got %d", got)
+	}
+
+	v, err := e.GetVariation(3)
+	if err != nil {
+		t.Fatalf("could not get variation: %s", err.Error())
+	}
+
+	if v.Levels["color"] != "red" || v.Levels["headline"] != "save" {
+		t.Fatalf("expected red and save, got %v", v.Levels)
+	}
+
+	if v.Description != "color=red, headline=save" {
+		t.Fatalf("unexpected description %s", v.Description)
+	}
+
+	if e.PreferredOrdinal != 1 {
+		t.Fatalf("expected the first combination to be preferred")
+	}
+
+	// 10 pulls per cell with 1, 2, 3 and 6 conversions
+	c, err := ParseSnapshot(strings.NewReader("4	signup:1	10	0.1	signup:2	10	0.2	signup:3	10	0.3	signup:4	10	0.6"))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	if err := e.Strategy.Init(c); err != nil {
+		t.Fatalf("could not init strategy: %s", err.Error())
+	}
+
+	effects, err := e.AnalyzeFactors()
+	if err != nil {
+		t.Fatalf("could not analyze factors: %s", err.Error())
+	}
+
+	expected := []struct {
+		factors  int
+		estimate float64
+	}{
+		{1, 0.3}, // color=red
+		{2, 0.2}, // color=red x headline=buy
+		{1, 0.2}, // headline=buy
+	}
+
+	if len(effects) != len(expected) {
+		t.Fatalf("expected %d effects, got %d", len(expected), len(effects))
+	}
+
+	for i, effect := range effects {
+		if got := len(effect.Factors); got != expected[i].factors {
+			t.Fatalf("effect %d: expected %d factors, got %d", i, expected[i].factors, got)
+		}
+
+		if got := effect.Estimate; math.Abs(got-expected[i].estimate) > 1e-9 {
+			t.Fatalf("effect %d: expected %.2f, got %f", i, expected[i].estimate, got)
+		}
+
+		if effect.StdErr <= 0 {
+			t.Fatalf("effect %d: expected a standard error, got %f", i, effect.StdErr)
+		}
+	}
+
+	// without pulls of red and buy, their interaction cannot be estimated
+	c, err = ParseSnapshot(strings.NewReader("4	signup:1	10	0.1	signup:2	10	0.2	signup:3	10	0.3	signup:4	0	0"))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	if err := e.Strategy.Init(c); err != nil {
+		t.Fatalf("could not init strategy: %s", err.Error())
+	}
+
+	effects, err = e.AnalyzeFactors()
+	if err != nil {
+		t.Fatalf("could not analyze factors: %s", err.Error())
+	}
+
+	for _, effect := range effects {
+		if len(effect.Factors) != 1 {
+			t.Fatalf("expected the interaction to be left out, got %v", effect)
+		}
+	}
+
+	if len(effects) != 2 {
+		t.Fatalf("expected 2 main effects, got %d", len(effects))
+	}
+
+	invalid := `[{
+		"experiment_name": "signup",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"factors": [{"name": "color", "levels": ["blue", "red"]}],
+		"variations": [{"url": "a", "ordinal": 1}]
+	}]`
+
+	if _, err := NewExperiment(stringOpener(invalid), "signup"); err == nil {
+		t.Fatalf("expected factors and variations to be rejected")
+	}
+}
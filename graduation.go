@@ -0,0 +1,247 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Graduation is the policy which declares the winner of an experiment once it
+// has clearly converged. It fires when the experiment has at least MinSample
+// pulls, and one variation is the best variation with probability PBest or
+// higher. Decisions are persisted to the Decisions file, so that restarts
+// keep serving the winner. Winners of best arm identification are persisted
+// to the Decisions file as well, which they require.
+type Graduation struct {
+	MinSample int     `json:"min-sample"`
+	PBest     float64 `json:"p-best"`
+	Decisions string  `json:"decisions"`
+}
+
+// enabled returns true if the policy is configured.
+func (g Graduation) enabled() bool {
+	return g.PBest > 0
+}
+
+// check returns an error if the policy is invalid.
+func (g Graduation) check() error {
+	if !g.enabled() {
+		return nil
+	}
+
+	if g.PBest > 1 {
+		return fmt.Errorf("graduation p-best must be in (0, 1], got %f", g.PBest)
+	}
+
+	if g.MinSample < 0 {
+		return fmt.Errorf("graduation min-sample must not be negative")
+	}
+
+	if g.Decisions == "" {
+		return fmt.Errorf("graduation needs a decisions file")
+	}
+
+	return nil
+}
+
+// Decision records the graduation of an experiment, with the evidence it was
+// based on.
+type Decision struct {
+	Winner   string        `json:"winner"` // tag of the winning variation
+	At       time.Time     `json:"at"`
+	Pulls    int           `json:"pulls"`
+	PBest    float64       `json:"p-best"`   // 0 for winners of best arm identification
+	Analysis []ArmAnalysis `json:"analysis"` // of all variations, in order of ordinals. nil for best arm identification.
+}
+
+// Decided returns the graduation decision, if the experiment has graduated.
+func (e *Experiment) Decided() (Decision, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.Decision == nil {
+		return Decision{}, false
+	}
+
+	return *e.Decision, true
+}
+
+// Graduate evaluates the graduation policy of the experiment and each of its
+// segments, which graduate independently, as well as the stopping rule of
+// best arm identifying strategies. Graduated experiments serve their winner
+// to everyone and stop exploring. Graduate is not called on selection, since
+// the analysis is expensive; call it periodically instead.
+func (e *Experiment) Graduate() error {
+	for _, x := range e.experiments() {
+		if err := x.declare(time.Now()); err != nil {
+			return err
+		}
+
+		if err := x.graduate(time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Graduate evaluates the graduation policy of all experiments.
+func (es *Experiments) Graduate() error {
+	for _, e := range *es {
+		if err := e.Graduate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// graduate declares the winner of this experiment, without its segments, if
+// the policy fires, and persists the decision.
+func (e *Experiment) graduate(now time.Time) error {
+	e.mu.RLock()
+	skip := !e.Graduation.enabled() || e.WinnerOrdinal > 0 || e.stateAt(now) != Running
+	e.mu.RUnlock()
+	if skip {
+		return nil
+	}
+
+	s, ok := e.Strategy.(snapshotter)
+	if !ok {
+		return fmt.Errorf("%s: cannot graduate %s", e.Name, e.Strategy)
+	}
+
+	c := s.Snapshot()
+	pulls := 0
+	for _, count := range c.counts {
+		pulls += count
+	}
+
+	if pulls < e.Graduation.MinSample {
+		return nil
+	}
+
+	analysis, err := e.analyze(c)
+	if err != nil {
+		return err
+	}
+
+	winner := 0
+	for i, arm := range analysis {
+		if arm.PBest > analysis[winner].PBest {
+			winner = i
+		}
+	}
+
+	if analysis[winner].PBest < e.Graduation.PBest {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.WinnerOrdinal > 0 || winner >= len(e.Variations) {
+		return nil // declared or resized in the meantime
+	}
+
+	decision := Decision{
+		Winner:   e.Variations[winner].Tag,
+		At:       now,
+		Pulls:    pulls,
+		PBest:    analysis[winner].PBest,
+		Analysis: analysis,
+	}
+
+	if err := saveDecision(e.Graduation.Decisions, e.Name, decision); err != nil {
+		return err
+	}
+
+	e.decide(decision)
+	return nil
+}
+
+// decide switches the experiment to the winner of the decision, which also
+// becomes the preferred variation. Decisions for unknown variations are
+// ignored.
+func (e *Experiment) decide(d Decision) {
+	v, err := e.taggedVariation(d.Winner)
+	if err != nil {
+		log.Printf("ignoring decision of %s: %s", e.Name, err.Error())
+		return
+	}
+
+	e.WinnerOrdinal = v.Ordinal
+	e.PreferredOrdinal = v.Ordinal
+	e.Decision = &d
+}
+
+// decisionsMu serializes updates of decision files.
+var decisionsMu sync.Mutex
+
+// readDecisions reads decisions by experiment name. A missing file has no
+// decisions.
+func readDecisions(path string) (map[string]Decision, error) {
+	decisions := make(map[string]Decision)
+	jsonString, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return decisions, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read decisions: %s", err.Error())
+	}
+
+	if err := json.Unmarshal(jsonString, &decisions); err != nil {
+		return nil, fmt.Errorf("could not marshal decisions: %s", err.Error())
+	}
+
+	return decisions, nil
+}
+
+// saveDecision adds the decision of experiment `name` to the decisions file.
+// The file is replaced atomically.
+func saveDecision(path, name string, d Decision) error {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+
+	decisions, err := readDecisions(path)
+	if err != nil {
+		return err
+	}
+
+	decisions[name] = d
+	jsonString, err := json.MarshalIndent(decisions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal decisions: %s", err.Error())
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("could not write decisions: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(jsonString); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write decisions: %s", err.Error())
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write decisions: %s", err.Error())
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("could not write decisions: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGraduation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decisions")
+	if err != nil {
+		t.Fatalf("could not create decisions dir: %s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := fmt.Sprintf(`[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"preferred": 1,
+		"graduation": {"min-sample": 2000, "p-best": 0.95, "decisions": "%s/decisions.json"},
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`, dir)
+
+	e, err := NewExperiment(stringOpener(config), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	converge := func(snapshot string) {
+		c, err := ParseSnapshot(strings.NewReader(snapshot))
+		if err != nil {
+			t.Fatalf("could not parse snapshot: %s", err.Error())
+		}
+
+		if err := e.Strategy.Init(c); err != nil {
+			t.Fatalf("could not init strategy: %s", err.Error())
+		}
+
+		if err := e.Graduate(); err != nil {
+			t.Fatalf("could not graduate: %s", err.Error())
+		}
+	}
+
+	converge("2	shape:1	500	0.10	shape:2	500	0.20")
+	if _, ok := e.Decided(); ok {
+		t.Fatalf("expected no graduation below the minimum sample")
+	}
+
+	converge("2	shape:1	1000	0.10	shape:2	1000	0.20")
+	decision, ok := e.Decided()
+	if !ok {
+		t.Fatalf("expected experiment to graduate")
+	}
+
+	if decision.Winner != "shape:2" || decision.Pulls != 2000 || decision.PBest < 0.95 {
+		t.Fatalf("unexpected decision %v", decision)
+	}
+
+	for i := 0; i < 100; i++ {
+		s, err := e.SelectRequest(Request{UID: fmt.Sprintf("%d", i)})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if s.Ordinal != 2 || s.Propensity != 1 {
+			t.Fatalf("expected graduated experiment to serve the winner, got %d", s.Ordinal)
+		}
+	}
+
+	restarted, err := NewExperiment(stringOpener(config), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	if restarted.WinnerOrdinal != 2 || restarted.PreferredOrdinal != 2 {
+		t.Fatalf("expected persisted decision to survive restarts")
+	}
+}
+
+func TestGraduationIdentification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "decisions")
+	if err != nil {
+		t.Fatalf("could not create decisions dir: %s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+
+	config := `[{
+		"experiment_name": "shape",
+		"strategy": "lucb",
+		"parameters": [0.05],
+		"preferred": 1,
+		%s
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`
+
+	if _, err := NewExperiment(stringOpener(fmt.Sprintf(config, "")), "shape"); err == nil {
+		t.Fatalf("expected best arm identification without decisions file to be rejected")
+	}
+
+	decisions := fmt.Sprintf(`"graduation": {"decisions": "%s/decisions.json"},`, dir)
+	e, err := NewExperiment(stringOpener(fmt.Sprintf(config, decisions)), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	c, err := ParseSnapshot(strings.NewReader("2	shape:1	5000	0.10	shape:2	5000	0.50"))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	if err := e.Strategy.Init(c); err != nil {
+		t.Fatalf("could not init strategy: %s", err.Error())
+	}
+
+	if err := e.Graduate(); err != nil {
+		t.Fatalf("could not graduate: %s", err.Error())
+	}
+
+	if decision, ok := e.Decided(); !ok || decision.Winner != "shape:2" || decision.Pulls != 10000 {
+		t.Fatalf("expected variation 2 to be declared the winner, got %v", decision)
+	}
+
+	restarted, err := NewExperiment(stringOpener(fmt.Sprintf(config, decisions)), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	if restarted.WinnerOrdinal != 2 || restarted.PreferredOrdinal != 2 {
+		t.Fatalf("expected declared winner to survive restarts")
+	}
+}
@@ -1,5 +1,11 @@
 package bandit
 
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
 // NewSimulatedDelayedStrategy simulates delayed strategy by flushing counters to
 // the underlying strategy after `flush` number of updates.
 func NewSimulatedDelayedStrategy(b Strategy, arms, flush int) Strategy {
@@ -32,6 +38,7 @@ func (b *simulatedDelayedStrategy) Update(arm int, reward float64) {
 	b.counts[arm]++
 	count := b.counts[arm]
 	b.values[arm] = ((b.values[arm] * float64(count-1)) + reward) / float64(count)
+	b.squares[arm] = ((b.squares[arm] * float64(count-1)) + reward*reward) / float64(count)
 
 	b.updates++
 	if b.updates >= b.limit {
@@ -39,3 +46,10 @@ func (b *simulatedDelayedStrategy) Update(arm int, reward float64) {
 		b.updates = 0
 	}
 }
+
+// stringOpener opens an in memory string, e.g. an experiments config.
+type stringOpener string
+
+func (o stringOpener) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(string(o))), nil
+}
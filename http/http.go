@@ -8,19 +8,27 @@ package http
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 
 	"github.com/purzelrakete/bandit"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // APIResponse is the json response on the HTTP API endpoint
 type APIResponse struct {
-	Experiment string `json:"experiment"`
-	URL        string `json:"url"`
-	Tag        string `json:"tag"`
+	Experiment string            `json:"experiment"`
+	URL        string            `json:"url"`
+	Tag        string            `json:"tag"`
+	Propensity float64           `json:"propensity"`
+	Position   int               `json:"position,omitempty"`
+	Layer      string            `json:"layer,omitempty"`
+	Payload    json.RawMessage   `json:"payload,omitempty"`
+	Levels     map[string]string `json:"levels,omitempty"`
 }
 
 // SelectionHandler can be used as an out of the box API endpoint for
@@ -40,6 +48,7 @@ type APIResponse struct {
 //       experiment: "widgets",
 //       url: "https://api/widget?color=blue"
 //       tag: "widget-sauce-flf89"
+//       propensity: 0.8
 //     }
 //
 // The client can now follow up with a request to the returned widget:
@@ -48,9 +57,45 @@ type APIResponse struct {
 //
 // This two phase approach can be collapsed by using the strategy directly
 // inside a golang api endpoint.
-func SelectionHandler(es *bandit.Experiments, ttl time.Duration) http.HandlerFunc {
+//
+// Contextual strategies take the request features either as a comma separated
+// query parameter, e.g. `features=1,0,0.5`, or as a json body with content
+// type application/json:
+//
+//     { "features": [1, 0, 0.5] }
+//
+// Requests with a `uid` are assigned to a variation by hashing the uid, so
+// that users see the same variation across devices and sessions. Timestamped
+// tags are ignored for these requests. Repeat visits of recently seen uids are
+// not logged as selections.
+//
+// Slate strategies select k variations at once with `k=3`. The response is a
+// json array of variations in order of position. Slates are selected like
+// single variations, but are neither pinned nor sticky.
+//
+// All other query parameters are request attributes, which are matched
+// against the audience and the segments of the experiment, e.g.
+// `country=de&platform=ios`. Json bodies carry them as an object:
+//
+//     { "features": [1, 0, 0.5], "attributes": { "country": "de" } }
+//
+// Requests outside the audience get the preferred variation with a blank tag.
+// These selections are not logged. Users outside the allocation get the
+// preferred variation with a blank tag as well, and are logged as not
+// enrolled. Requests without a uid are outside partial allocations.
+//
+// QA can force a variation with an override signed by the keyring, given as
+// `override=shape-20130822:2:<expiry>:<signature>` or as a `bandit-override`
+// cookie with comma separated overrides. Expired overrides are ignored. Uids on the allowlist of a variation get
+// that variation. Overrides have a blank tag and are logged as overrides.
+//
+// If a keyring is given, timestamped tags are signed, e.g.
+// `shape-20130822:2:1379257984:<signature>`, so that rewards cannot be forged.
+// A nil keyring disables signatures and signed overrides.
+func SelectionHandler(source bandit.Source, ttl time.Duration, keyring *bandit.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
+		es := source.Current()
 		w.Header().Set("Content-Type", "text/json")
 
 		name := r.URL.Query().Get(":name")
@@ -60,8 +105,25 @@ func SelectionHandler(es *bandit.Experiments, ttl time.Duration) http.HandlerFun
 			return
 		}
 
+		request, err := newRequest(r, keyring)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if k := r.URL.Query().Get("k"); k != "" {
+			selectSlate(w, e, request, k, keyring)
+			return
+		}
+
 		timestampedTag := r.URL.Query().Get(":tag")
-		variation, newTag, err := e.SelectTimestamped(timestampedTag, ttl)
+		if timestampedTag != "" && keyring != nil {
+			if timestampedTag, err = keyring.VerifyTag(timestampedTag); err != nil {
+				log.Printf("repinned after error: %s", err.Error())
+			}
+		}
+
+		selection, newTag, err := e.SelectTimestampedRequest(timestampedTag, request, ttl)
 		if err != nil {
 			http.Error(w, "could not select variation", http.StatusInternalServerError)
 			return
@@ -69,8 +131,11 @@ func SelectionHandler(es *bandit.Experiments, ttl time.Duration) http.HandlerFun
 
 		json, err := json.Marshal(APIResponse{
 			Experiment: e.Name,
-			URL:        variation.URL,
-			Tag:        newTag,
+			URL:        selection.URL,
+			Payload:    selection.Payload,
+			Levels:     selection.Levels,
+			Tag:        sign(keyring, newTag),
+			Propensity: selection.Propensity,
 		})
 
 		if err != nil {
@@ -78,54 +143,569 @@ func SelectionHandler(es *bandit.Experiments, ttl time.Duration) http.HandlerFun
 			return
 		}
 
-		log.Println(bandit.SelectionLine(*e, variation))
+		if !selection.Excluded && !selection.Sticky {
+			log.Println(bandit.SelectionLine(e, selection))
+		}
+
+		w.Write(json)
+	}
+}
+
+// AssignmentsHandler selects the variation of a user in every layer. The
+// response is a json array with one variation per layer, in order of layer:
+//
+//     GET https://api/assignments?uid=11 HTTP/1.0
+//
+//     [
+//       {experiment: "widgets", url: "...", tag: "widgets:2:1379257984", propensity: 0.5, layer: "checkout"},
+//       {experiment: "fonts", url: "...", tag: "fonts:1:1379257984", propensity: 0.5, layer: "fonts"}
+//     ]
+//
+// Attributes, features and overrides are given as in SelectionHandler.
+// Variations which are not counted have a blank tag.
+func AssignmentsHandler(source bandit.Source, keyring *bandit.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		es := source.Current()
+		w.Header().Set("Content-Type", "text/json")
+
+		request, err := newRequest(r, keyring)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		assignments, err := es.SelectAllRequest(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now().Unix()
+		var responses []APIResponse
+		for _, assignment := range assignments {
+			response := APIResponse{
+				Experiment: assignment.Experiment.Name,
+				URL:        assignment.URL,
+				Payload:    assignment.Payload,
+				Levels:     assignment.Levels,
+				Propensity: assignment.Propensity,
+				Layer:      assignment.Layer,
+			}
+
+			if assignment.Counted() {
+				response.Tag = sign(keyring, assignment.TimestampedTag(now))
+			}
+
+			responses = append(responses, response)
+		}
+
+		json, err := json.Marshal(responses)
+		if err != nil {
+			http.Error(w, "could not build assignments", http.StatusInternalServerError)
+			return
+		}
+
+		for _, assignment := range assignments {
+			if !assignment.Excluded && !assignment.Sticky {
+				log.Println(bandit.SelectionLine(assignment.Experiment, assignment.Selection))
+			}
+		}
+
 		w.Write(json)
 	}
 }
 
+// selectSlate writes k variations selected by a slate strategy.
+func selectSlate(w http.ResponseWriter, e *bandit.Experiment, request bandit.Request, k string, keyring *bandit.Keyring) {
+	n, err := strconv.Atoi(k)
+	if err != nil {
+		http.Error(w, "k is not an integer", http.StatusBadRequest)
+		return
+	}
+
+	slate, err := e.SelectSlate(request, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	var responses []APIResponse
+	for _, selection := range slate {
+		response := APIResponse{
+			Experiment: e.Name,
+			URL:        selection.URL,
+			Payload:    selection.Payload,
+			Levels:     selection.Levels,
+			Propensity: selection.Propensity,
+			Position:   selection.Position,
+		}
+
+		if selection.Counted() {
+			response.Tag = sign(keyring, selection.TimestampedTag(now))
+		}
+
+		responses = append(responses, response)
+	}
+
+	json, err := json.Marshal(responses)
+	if err != nil {
+		http.Error(w, "could not build variations", http.StatusInternalServerError)
+		return
+	}
+
+	for _, selection := range slate {
+		if !selection.Excluded {
+			log.Println(bandit.SelectionLine(e, selection))
+		}
+	}
+
+	w.Write(json)
+}
+
 // LogRewardHandler logs reward lines. It's better to log rewards directly
 // through your main logging pipeline, but the handler is here in case you
 // can't do that. This handler is currently updates the supplied strategys
-// directly, which makes it unsuitable for real use.
-func LogRewardHandler(es *bandit.Experiments) http.HandlerFunc {
+// directly, which makes it unsuitable for real use. Rewards of single
+// variations of a slate take the 1 indexed `position` of the rewarded
+// variation. Whole slates are rewarded with comma separated tags and rewards
+// in order of position, e.g. `tag=shape:3:1379257984,shape:1:1379257984` and
+// `reward=0,1`, which updates slate strategies with UpdateSlate. Rewards of
+// contextual selections take the features of the selection as
+// `features=1,0,0.5`, which are logged with the reward. Tags carry the
+// propensity of the selection, which strategies weighting rewards by their
+// inverse propensity use instead of their current probabilities. Rewards of
+// tags older than `ttl` are rejected, unless `ttl` is 0, as are rewards
+// outside the range assumed by the strategy, e.g. [0, 1] for Bernoulli
+// rewards. If a keyring is given, rewards of tags without a valid signature
+// are rejected, and the signed tag is logged so that bandit-job can verify it.
+// Signed rewards need a `ttl`, since signed tags could be replayed forever.
+func LogRewardHandler(source bandit.Source, ttl time.Duration, keyring *bandit.Keyring) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
+		es := source.Current()
 		w.Header().Set("Content-Type", "text/application")
 
-		timestampedTag := r.URL.Query().Get("tag")
-		if timestampedTag == "" {
+		tags := r.URL.Query().Get("tag")
+		if tags == "" {
 			http.Error(w, "cannot reward without tag", http.StatusBadRequest)
 			return
 		}
 
-		tag, _, err := bandit.TimestampedTagToTag(timestampedTag)
-		if err != nil {
-			http.Error(w, "could not covert timestampedTag to tag", http.StatusBadRequest)
-			return
-		}
-
 		reward := r.URL.Query().Get("reward")
 		if reward == "" {
 			http.Error(w, "reward missing", http.StatusBadRequest)
 			return
 		}
 
-		fReward, err := strconv.ParseFloat(reward, 64)
+		signedTags, rewards := strings.Split(tags, ","), strings.Split(reward, ",")
+		if len(signedTags) != len(rewards) {
+			http.Error(w, "need one reward per tag", http.StatusBadRequest)
+			return
+		}
+
+		features, err := requestFeatures(r)
 		if err != nil {
-			http.Error(w, "reward is not a float", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var e *bandit.Experiment
+		var variations, logged []bandit.Variation
+		var fRewards, propensities []float64
+		for i, signedTag := range signedTags {
+			tag, propensity, err := rewardedTag(signedTag, ttl, keyring)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			fReward, err := strconv.ParseFloat(rewards[i], 64)
+			if err != nil {
+				http.Error(w, "reward is not a float", http.StatusBadRequest)
+				return
+			}
+
+			x, variation, err := es.GetVariation(tag)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if e != nil && x != e {
+				http.Error(w, "slate spans experiments", http.StatusBadRequest)
+				return
+			}
+
+			e = x
+			variations = append(variations, variation)
+			fRewards = append(fRewards, fReward)
+			propensities = append(propensities, propensity)
+
+			if keyring != nil {
+				variation.Tag = signedTag
+			}
+
+			logged = append(logged, variation)
+		}
+
+		if len(variations) > 1 {
+			if features != nil {
+				http.Error(w, "slates are not contextual", http.StatusBadRequest)
+				return
+			}
+
+			if err := e.UpdateSlate(variations, fRewards); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			for i, variation := range logged {
+				log.Println(bandit.PositionRewardLine(e, variation, i+1, fRewards[i]))
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		line := bandit.RewardLine(e, logged[0], fRewards[0])
+		if features != nil {
+			line = bandit.ContextRewardLine(e, logged[0], features, fRewards[0])
+		}
+
+		if position := r.URL.Query().Get("position"); position != "" {
+			iPosition, err := strconv.Atoi(position)
+			if err != nil {
+				http.Error(w, "position is not an integer", http.StatusBadRequest)
+				return
+			}
+
+			line = bandit.PositionRewardLine(e, logged[0], iPosition, fRewards[0])
+		}
+
+		if err := e.UpdateWithPropensity(variations[0], features, propensities[0], fRewards[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Println(line)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// rewardedTag returns the tag of a timestamped tag given with a reward, and
+// the propensity it carries. Tags without a valid signature are rejected if a
+// keyring is given, and tags older than ttl are rejected unless ttl is 0.
+// Signed tags need a ttl.
+func rewardedTag(signedTag string, ttl time.Duration, keyring *bandit.Keyring) (string, float64, error) {
+	timestampedTag := signedTag
+	if keyring != nil {
+		// signed tags would be replayable forever
+		if ttl <= 0 {
+			return "", 0, fmt.Errorf("signed rewards need a ttl")
+		}
+
+		var err error
+		if timestampedTag, err = keyring.VerifyTag(signedTag); err != nil {
+			return "", 0, err
+		}
+	}
+
+	tag, timestamp, err := bandit.TimestampedTagToTag(timestampedTag)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not covert timestampedTag to tag")
+	}
+
+	// replayed tags expire with their pin
+	if ttl > 0 && time.Since(time.Unix(timestamp, 0)) > ttl {
+		return "", 0, fmt.Errorf("tag expired")
+	}
+
+	propensity, err := bandit.TimestampedTagPropensity(timestampedTag)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return tag, propensity, nil
+}
+
+// AnalysisResponse is the json response of the analysis endpoint.
+type AnalysisResponse struct {
+	Experiment string              `json:"experiment"`
+	Variations []VariationAnalysis `json:"variations"`
+	Effects    []bandit.Effect     `json:"effects,omitempty"`
+}
+
+// VariationAnalysis is the analysis of a single variation.
+type VariationAnalysis struct {
+	Tag string `json:"tag"`
+	URL string `json:"url"`
+	bandit.ArmAnalysis
+}
+
+// AnalysisHandler reports the probability that each variation is the best
+// variation, and the expected loss of choosing it:
+//
+//     GET https://api/experiments/widgets/analysis HTTP/1.0
+//
+//     {
+//       experiment: "widgets",
+//       variations: [
+//         {tag: "widgets:1", url: "...", pulls: 1200, mean: 0.1, p-best: 0.08, expected-loss: 0.02},
+//         {tag: "widgets:2", url: "...", pulls: 1400, mean: 0.12, p-best: 0.92, expected-loss: 0.0004}
+//       ]
+//     }
+//
+// Factorial experiments additionally report the estimated main effects and
+// interactions of their factors:
+//
+//     effects: [
+//       {factors: ["color"], levels: ["red"], estimate: 0.02, std-err: 0.008},
+//       ...
+//     ]
+func AnalysisHandler(source bandit.Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		es := source.Current()
+		w.Header().Set("Content-Type", "text/json")
+
+		name := r.URL.Query().Get(":name")
+		e, ok := (*es)[name]
+		if ok != true {
+			http.Error(w, "invalid experiment", http.StatusBadRequest)
 			return
 		}
 
-		e, variation, err := es.GetVariation(tag)
+		analysis, err := e.Analyze()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		b := (*es)[e.Name].Strategy
-		b.Update(variation.Ordinal, fReward)
+		response := AnalysisResponse{Experiment: e.Name}
+		for i, arm := range analysis {
+			variation, err := e.GetVariation(i + 1)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
 
-		log.Println(bandit.RewardLine(e, variation, fReward))
-		w.WriteHeader(http.StatusOK)
+			response.Variations = append(response.Variations, VariationAnalysis{
+				Tag:         variation.Tag,
+				URL:         variation.URL,
+				ArmAnalysis: arm,
+			})
+		}
+
+		if len(e.Factors) > 0 {
+			if response.Effects, err = e.AnalyzeFactors(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		json, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, "could not build analysis", http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(json)
+	}
+}
+
+// StatusResponse is the json response of the status endpoint.
+type StatusResponse struct {
+	Experiment string                      `json:"experiment"`
+	State      string                      `json:"state"`
+	Start      string                      `json:"start,omitempty"`
+	End        string                      `json:"end,omitempty"`
+	Preferred  string                      `json:"preferred"` // tag served by experiments which are not running
+	Decision   *bandit.Decision            `json:"decision,omitempty"`
+	Stale      bool                        `json:"stale"` // a snapshot of the experiment or of a segment is stale
+	Snapshots  map[string]bandit.Freshness `json:"snapshots,omitempty"`
+}
+
+// StatusHandler reports the lifecycle state of each experiment, in order of
+// name. Graduated experiments report the decision and its evidence.
+// Experiments with snapshots report the freshness of the snapshots of the
+// experiment and its segments, so that alerting can pick up stale ones:
+//
+//     GET https://api/experiments HTTP/1.0
+//
+//     [
+//       {experiment: "widgets", state: "running", end: "2013-09-01T00:00:00Z", preferred: "widgets:2", stale: false},
+//       {experiment: "shapes", state: "running", preferred: "shapes:3", decision: {winner: "shapes:3", p-best: 0.97, ...}, stale: false},
+//       {experiment: "fonts", state: "running", preferred: "fonts:1", stale: true, snapshots: {
+//         "fonts": {failures: 3, age-seconds: 7200, stale: true, fallback: "preferred", ...}
+//       }}
+//     ]
+func StatusHandler(source bandit.Source) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		es := source.Current()
+		w.Header().Set("Content-Type", "text/json")
+
+		var names []string
+		for name := range *es {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		var responses []StatusResponse
+		for _, name := range names {
+			e := (*es)[name]
+			preferred, err := e.GetVariation(e.PreferredOrdinal)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			response := StatusResponse{
+				Experiment: e.Name,
+				State:      string(e.Status()),
+				Preferred:  preferred.Tag,
+			}
+
+			if !e.Start.IsZero() {
+				response.Start = e.Start.Format(time.RFC3339)
+			}
+
+			if !e.End.IsZero() {
+				response.End = e.End.Format(time.RFC3339)
+			}
+
+			if decision, ok := e.Decided(); ok {
+				response.Decision = &decision
+			}
+
+			if snapshots := e.Freshness(); len(snapshots) > 0 {
+				response.Snapshots = snapshots
+				for _, f := range snapshots {
+					response.Stale = response.Stale || f.Stale
+				}
+			}
+
+			responses = append(responses, response)
+		}
+
+		json, err := json.Marshal(responses)
+		if err != nil {
+			http.Error(w, "could not build status", http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(json)
+	}
+}
+
+// reserved query parameters are not request attributes.
+var reserved = map[string]bool{
+	"features": true,
+	"k":        true,
+	"override": true,
+	"position": true,
+	"reward":   true,
+	"tag":      true,
+	"uid":      true,
+}
+
+// overrideCookie carries comma separated signed overrides.
+const overrideCookie = "bandit-override"
+
+// sign signs a timestamped tag if a keyring is given. Blank tags stay blank.
+func sign(keyring *bandit.Keyring, timestampedTag string) string {
+	if keyring == nil || timestampedTag == "" {
+		return timestampedTag
+	}
+
+	return keyring.SignTag(timestampedTag)
+}
+
+// newRequest reads the uid, features, attributes and overrides of the
+// request. Overrides are ignored if their signature is invalid, or if there
+// is no keyring.
+func newRequest(r *http.Request, keyring *bandit.Keyring) (bandit.Request, error) {
+	request := bandit.Request{
+		UID:        r.URL.Query().Get("uid"),
+		Attributes: make(map[string]string),
+	}
+
+	var overrides []string
+	if override := r.URL.Query().Get("override"); override != "" {
+		overrides = append(overrides, override)
+	}
+
+	if cookie, err := r.Cookie(overrideCookie); err == nil {
+		overrides = append(overrides, strings.Split(cookie.Value, ",")...)
+	}
+
+	for _, override := range overrides {
+		if keyring == nil {
+			break
+		}
+
+		tag, err := keyring.VerifyOverride(override, time.Now())
+		if err != nil {
+			log.Printf("ignored override: %s", err.Error())
+			continue
+		}
+
+		request.Overrides = append(request.Overrides, tag)
+	}
+
+	for name, values := range r.URL.Query() {
+		if !reserved[name] && !strings.HasPrefix(name, ":") {
+			request.Attributes[name] = values[0]
+		}
 	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Features   []float64         `json:"features"`
+			Attributes map[string]string `json:"attributes"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return bandit.Request{}, fmt.Errorf("could not decode request: %s", err.Error())
+		}
+
+		for name, value := range body.Attributes {
+			request.Attributes[name] = value
+		}
+
+		request.Features = body.Features
+		return request, nil
+	}
+
+	features, err := requestFeatures(r)
+	if err != nil {
+		return bandit.Request{}, err
+	}
+
+	request.Features = features
+	return request, nil
+}
+
+// requestFeatures reads comma separated features for contextual strategies
+// from the query. Returns nil if the request carries no features.
+func requestFeatures(r *http.Request) ([]float64, error) {
+	query := r.URL.Query().Get("features")
+	if query == "" {
+		return nil, nil
+	}
+
+	var features []float64
+	for _, str := range strings.Split(query, ",") {
+		feature, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, fmt.Errorf("feature is not a float: %s", err.Error())
+		}
+
+		features = append(features, feature)
+	}
+
+	return features, nil
 }
@@ -0,0 +1,437 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BestArmIdentifier strategies look for the best arm at a fixed confidence
+// rather than minimising regret, as in a classic A/B test. Done returns the
+// 1 indexed best arm so far, and whether the stopping rule has fired.
+type BestArmIdentifier interface {
+	Strategy
+	Done() (winner int, confident bool)
+}
+
+// NewSuccessiveElimination constructs a successive elimination strategy
+// (Even-Dar et al., 2006). Rewards must be in [0, 1]. The best arm is
+// identified with probability at least 1 - δ.
+func NewSuccessiveElimination(arms int, δ float64) (Strategy, error) {
+	if !(δ > 0 && δ < 1) {
+		return &successiveElimination{}, fmt.Errorf("δ not in (0, 1)")
+	}
+
+	s := &successiveElimination{
+		Counters: NewCounters(arms),
+		delta:    δ,
+	}
+
+	s.Reset()
+	return s, nil
+}
+
+// successiveElimination pulls all active arms in turn and eliminates arms
+// whose upper confidence bound falls below the lower confidence bound of
+// another active arm.
+type successiveElimination struct {
+	Counters
+	delta  float64 // 1 - confidence
+	active []bool  // arms which have not been eliminated
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (s *successiveElimination) SelectArm() int {
+	imin := s.candidates()
+	arm := imin[s.rand.Intn(len(imin))]
+
+	s.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (s *successiveElimination) Probabilities() []float64 {
+	return uniformOver(s.candidates(), s.arms)
+}
+
+// candidates returns the 0 indexed active arms with the fewest pulls.
+func (s *successiveElimination) candidates() []int {
+	var imin []int
+	for i, active := range s.active {
+		switch {
+		case !active:
+		case len(imin) == 0 || s.counts[i] < s.counts[imin[0]]:
+			imin = []int{i}
+		case s.counts[i] == s.counts[imin[0]]:
+			imin = append(imin, i)
+		}
+	}
+
+	return imin
+}
+
+// Update the 1 indexed arm and eliminate arms which are worse than another
+// arm with high probability.
+func (s *successiveElimination) Update(arm int, reward float64) {
+	s.Counters.Update(arm, reward)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.eliminate()
+}
+
+// eliminate deactivates arms whose upper confidence bound is below the
+// highest lower confidence bound.
+func (s *successiveElimination) eliminate() {
+	lower, upper := make([]float64, s.arms), make([]float64, s.arms)
+	for i := range s.active {
+		n := float64(s.counts[i])
+		if n == 0 {
+			return
+		}
+
+		radius := math.Sqrt(math.Log(4*float64(s.arms)*n*n/s.delta) / (2 * n))
+		lower[i], upper[i] = s.values[i]-radius, s.values[i]+radius
+	}
+
+	best := math.Inf(-1)
+	for i, active := range s.active {
+		if active {
+			best = math.Max(best, lower[i])
+		}
+	}
+
+	for i, active := range s.active {
+		if active && upper[i] < best {
+			s.active[i] = false
+		}
+	}
+}
+
+// Done returns the active arm with the highest mean reward. The strategy is
+// confident once all other arms are eliminated.
+func (s *successiveElimination) Done() (int, bool) {
+	winner, remaining := -1, 0
+	for i, active := range s.active {
+		if !active {
+			continue
+		}
+
+		remaining++
+		if winner < 0 || s.values[i] > s.values[winner] {
+			winner = i
+		}
+	}
+
+	return winner + 1, remaining == 1
+}
+
+// Init the strategy from a snapshot. Arms are eliminated again.
+func (s *successiveElimination) Init(c *Counters) error {
+	if err := s.Counters.Init(c); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.activate()
+	s.eliminate()
+	return nil
+}
+
+// Reset the strategy to initial state.
+func (s *successiveElimination) Reset() {
+	s.Counters.Reset()
+	s.activate()
+}
+
+// activate makes all arms active.
+func (s *successiveElimination) activate() {
+	s.active = make([]bool, s.arms)
+	for i := range s.active {
+		s.active[i] = true
+	}
+}
+
+// addArm appends an active arm.
+func (s *successiveElimination) addArm(count int, value float64) {
+	s.Counters.addArm(count, value)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.active = append(s.active, true)
+}
+
+// removeArm removes the 1 indexed arm.
+func (s *successiveElimination) removeArm(arm int) {
+	s.Counters.removeArm(arm)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.active = append(append([]bool{}, s.active[:arm-1]...), s.active[arm:]...)
+}
+
+// String returns information on this strategy
+func (s *successiveElimination) String() string {
+	return fmt.Sprintf("SuccessiveElimination(delta=%.2f)", s.delta)
+}
+
+// NewLUCB constructs a LUCB1 strategy (Kalyanakrishnan et al., 2012). Rewards
+// must be in [0, 1]. The best arm is identified with probability at least
+// 1 - δ.
+func NewLUCB(arms int, δ float64) (Strategy, error) {
+	if !(δ > 0 && δ < 1) {
+		return &lUCB{}, fmt.Errorf("δ not in (0, 1)")
+	}
+
+	return &lUCB{
+		Counters: NewCounters(arms),
+		delta:    δ,
+	}, nil
+}
+
+// lUCB pulls the empirically best arm and its strongest challenger, the other
+// arm with the highest upper confidence bound, until the confidence intervals
+// of both separate.
+type lUCB struct {
+	Counters
+	delta   float64 // 1 - confidence
+	pending []int   // 0 indexed arms still to be pulled in this round
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (l *lUCB) SelectArm() int {
+	arm := l.next()
+	if len(l.pending) > 0 && l.pending[0] == arm {
+		l.pending = l.pending[1:]
+	}
+
+	l.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (l *lUCB) Probabilities() []float64 {
+	return uniformOver([]int{l.next()}, l.arms)
+}
+
+// next returns the 0 indexed arm to be pulled next. Untried arms are pulled
+// first, then the best arm and its challenger in turn.
+func (l *lUCB) next() int {
+	for i, count := range l.counts {
+		if count == 0 {
+			return i
+		}
+	}
+
+	if len(l.pending) == 0 {
+		best, challenger := l.pair()
+		l.pending = []int{best, challenger}
+	}
+
+	return l.pending[0]
+}
+
+// pair returns the 0 indexed empirically best arm and its challenger.
+func (l *lUCB) pair() (int, int) {
+	_, imax := bmath.Max(l.values)
+	best, challenger := imax[0], -1
+	for i := range l.values {
+		if i == best {
+			continue
+		}
+
+		if challenger < 0 || l.values[i]+l.radius(i) > l.values[challenger]+l.radius(challenger) {
+			challenger = i
+		}
+	}
+
+	return best, challenger
+}
+
+// radius is the width of the confidence interval of the 0 indexed arm.
+func (l *lUCB) radius(arm int) float64 {
+	n := float64(l.counts[arm])
+	if n == 0 {
+		return math.Inf(1)
+	}
+
+	total := 0.0
+	for _, count := range l.counts {
+		total += float64(count)
+	}
+
+	k := float64(l.arms)
+	return math.Sqrt(math.Log(5*k*math.Pow(total, 4)/(4*l.delta)) / (2 * n))
+}
+
+// Done returns the empirically best arm. The strategy is confident once the
+// lower bound of the best arm exceeds the upper bound of its challenger.
+func (l *lUCB) Done() (int, bool) {
+	if l.arms < 2 {
+		return 1, true
+	}
+
+	best, challenger := l.pair()
+	confident := l.values[best]-l.radius(best) > l.values[challenger]+l.radius(challenger)
+	return best + 1, confident
+}
+
+// Init the strategy from a snapshot.
+func (l *lUCB) Init(c *Counters) error {
+	if err := l.Counters.Init(c); err != nil {
+		return err
+	}
+
+	l.pending = nil
+	return nil
+}
+
+// Reset the strategy to initial state.
+func (l *lUCB) Reset() {
+	l.Counters.Reset()
+	l.pending = nil
+}
+
+// addArm appends an arm and starts a new round.
+func (l *lUCB) addArm(count int, value float64) {
+	l.Counters.addArm(count, value)
+	l.pending = nil
+}
+
+// removeArm removes the 1 indexed arm and starts a new round.
+func (l *lUCB) removeArm(arm int) {
+	l.Counters.removeArm(arm)
+	l.pending = nil
+}
+
+// String returns information on this strategy
+func (l *lUCB) String() string {
+	return fmt.Sprintf("LUCB(delta=%.2f)", l.delta)
+}
+
+// NewTopTwoThompson constructs a top-two thompson sampling strategy (Russo,
+// 2016) for Bernoulli rewards. The leader of a posterior sample is pulled
+// with probability β, its challenger otherwise. The strategy stops once the
+// posterior probability of the best arm exceeds 1 - δ.
+func NewTopTwoThompson(arms int, β, δ float64) (Strategy, error) {
+	if !(β >= 0 && β <= 1) {
+		return &topTwoThompson{}, fmt.Errorf("β not in [0, 1]")
+	}
+
+	if !(δ > 0 && δ < 1) {
+		return &topTwoThompson{}, fmt.Errorf("δ not in (0, 1)")
+	}
+
+	return &topTwoThompson{
+		Counters: NewCounters(arms),
+		beta:     β,
+		delta:    δ,
+		betaRand: bmath.NewBetaRand(time.Now().UnixNano()),
+	}, nil
+}
+
+// topTwoThompson is thompson sampling which keeps exploring the challenger of
+// the best arm, rather than settling on the best arm.
+type topTwoThompson struct {
+	Counters
+	beta      float64 // probability of pulling the leader
+	delta     float64 // 1 - confidence
+	betaRand  *bmath.BetaRand
+	estimates estimates // cached selection probabilities
+}
+
+// topTwoResamples is the number of posterior samples drawn to find a
+// challenger which differs from the leader.
+const topTwoResamples = 100
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (t *topTwoThompson) SelectArm() int {
+	arm := t.choose()
+
+	t.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns Monte Carlo estimates of the probability of selecting
+// each arm. Estimates are cached until the next reward or snapshot.
+func (t *topTwoThompson) Probabilities() []float64 {
+	return t.estimates.get(&t.Counters, func() []float64 {
+		ps := make([]float64, t.arms)
+		for draw := 0; draw < probabilityDraws; draw++ {
+			ps[t.choose()] += 1 / float64(probabilityDraws)
+		}
+
+		return ps
+	})
+}
+
+// choose returns the 0 indexed leader with probability β, and a challenger
+// otherwise.
+func (t *topTwoThompson) choose() int {
+	leader := t.leader()
+	if t.rand.Float64() < t.beta {
+		return leader
+	}
+
+	for i := 0; i < topTwoResamples; i++ {
+		if challenger := t.leader(); challenger != leader {
+			return challenger
+		}
+	}
+
+	return leader
+}
+
+// leader returns the 0 indexed arm with the highest posterior sample.
+func (t *topTwoThompson) leader() int {
+	thetas := make([]float64, t.arms)
+	for i := 0; i < t.arms; i++ {
+		thetas[i] = t.sample(i)
+	}
+
+	_, imax := bmath.Max(thetas)
+	return imax[t.rand.Intn(len(imax))]
+}
+
+// sample draws the mean reward of the 0 indexed arm from its posterior, with
+// a uniform prior.
+func (t *topTwoThompson) sample(arm int) float64 {
+	n := float64(t.counts[arm])
+	si := t.values[arm] * n
+	fi := n - si
+	return t.betaRand.NextBeta(si+1, fi+1)
+}
+
+// Done returns the arm with the highest posterior probability of being the
+// best arm. The strategy is confident once this probability exceeds 1 - δ.
+func (t *topTwoThompson) Done() (int, bool) {
+	ps := estimateProbabilities(t.arms, t.sample)
+	max, imax := bmath.Max(ps)
+	return imax[0] + 1, max >= 1-t.delta
+}
+
+// rewardRange returns [0, 1], rewards are Bernoulli.
+func (t *topTwoThompson) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// Seed replaces the random source of the strategy and its sampler.
+func (t *topTwoThompson) Seed(src rand.Source) {
+	t.Counters.Seed(src)
+	t.betaRand = bmath.NewBetaRandSource(src)
+}
+
+// String returns information on this strategy
+func (t *topTwoThompson) String() string {
+	return fmt.Sprintf("TopTwoThompson(beta=%.2f, delta=%.2f)", t.beta, t.delta)
+}
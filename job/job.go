@@ -2,9 +2,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"github.com/purzelrakete/bandit"
 	"io"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // mapper returns a hadoop streaming mapper function. Emits (arm, reward)
@@ -20,6 +26,10 @@ func mapper(s *statistics, r io.Reader, w io.Writer) func() {
 				}
 			}
 		}
+
+		if invalid := s.invalidRewards(); invalid > 0 {
+			log.Printf("skipped %d rewards with invalid signatures", invalid)
+		}
 	}
 }
 
@@ -37,15 +47,39 @@ func reducer(s *statistics, r io.Reader, w io.Writer) func() {
 
 		for _, stat := range s.stats {
 			if values, ok := stat.result(); ok {
-				for key, value := range values {
-					fmt.Fprintf(w, "%s	%d	%f\n", stat.getPrefix(), key+1, value)
+				var keys []int
+				for key := range values {
+					keys = append(keys, key)
+				}
+
+				sort.Ints(keys)
+				for _, key := range keys {
+					fmt.Fprintf(w, "%s	%d	%f\n", stat.getPrefix(), key+1, values[key])
+				}
+			}
+
+			if v, ok := stat.(vectorStats); ok {
+				vectors := v.vectors()
+				var keys []int
+				for key := range vectors {
+					keys = append(keys, key)
+				}
+
+				sort.Ints(keys)
+				for _, key := range keys {
+					var values []string
+					for _, value := range vectors[key] {
+						values = append(values, strconv.FormatFloat(value, 'f', -1, 64))
+					}
+
+					fmt.Fprintf(w, "%s	%d	%s\n", stat.getPrefix(), key+1, strings.Join(values, ","))
 				}
 			}
 		}
 	}
 }
 
-// collector aggregates outputs of reducers
+// collector aggregates outputs of reducers into a version 2 snapshot
 func collector(s *statistics, r io.Reader, w io.Writer) func() {
 	return func() {
 		scanner := bufio.NewScanner(r)
@@ -56,19 +90,39 @@ func collector(s *statistics, r io.Reader, w io.Writer) func() {
 			}
 		}
 
-		fmt.Fprint(w, tsvSnapshot(s.rewards()), "\n")
+		snapshot, err := json.Marshal(s.snapshot(time.Now()))
+		if err != nil {
+			log.Fatalf("could not marshal snapshot: %s", err.Error())
+		}
+
+		fmt.Fprint(w, string(snapshot), "\n")
 	}
 }
 
-// tsvSnapshot is the tsv formatted snapshot file.
-func tsvSnapshot(counts []int, rewards []float64) string {
-	var values []string
-	for _, reward := range rewards {
-		values = append(values, fmt.Sprintf("%f", float64(reward)))
-	}
+// bundler merges version 2 snapshots into a bundle, keyed by experiment name.
+func bundler(snapshots []io.Reader, w io.Writer) func() {
+	return func() {
+		bundle := bandit.Bundle{
+			Version:    bandit.SnapshotVersion,
+			Generated:  time.Now().UTC(),
+			JobVersion: jobVersion,
+			Snapshots:  make(map[string]bandit.Snapshot),
+		}
+
+		for _, r := range snapshots {
+			snapshot, err := bandit.DecodeSnapshot(r)
+			if err != nil {
+				log.Fatalf("could not bundle snapshot: %s", err.Error())
+			}
 
-	return strings.Join([]string{
-		fmt.Sprintf("%d", len(rewards)),
-		strings.Join(values, "\t"),
-	}, "\t")
+			bundle.Snapshots[snapshot.Experiment] = snapshot
+		}
+
+		json, err := json.Marshal(bundle)
+		if err != nil {
+			log.Fatalf("could not marshal bundle: %s", err.Error())
+		}
+
+		fmt.Fprint(w, string(json), "\n")
+	}
 }
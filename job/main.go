@@ -1,30 +1,63 @@
 // Package main contains bandit-job, which takes as input a log of selects and
 // rewards of the following format:
 //
-// 1379257984 BanditSelection shape-20130822:1:8932478932
+// 1379257984 BanditSelection shape-20130822:1:8932478932 0.500000
 // 1379257987 BanditReward shape-20130822:1:8932478932 0.000000
 //
 // Fields are interpreted as follows:
 //
-// (logline-timestamp, kind, tag, reward)
+// (logline-timestamp, kind, tag, propensity, position)
+// (logline-timestamp, kind, tag, reward, position)
+//
+// The propensity of selection lines is optional. Positions are only logged
+// for slates. Contextual selections and rewards end in their features, e.g.
+// `features=1,0,0.5`. Rewards with features are summed into a regression of
+// the reward on the features, which snapshots carry for contextual
+// strategies. BanditNotEnrolled lines of users outside the allocation of an
+// experiment and BanditOverride lines of forced variations are not counted as
+// selections.
 //
 // Tags are interpreted as:
 //
-// experiment-name:variation-ordinal:pinning-time
+// experiment-name:variation-id:pinning-time
+//
+// Variation ids are stable. They equal the ordinal of the variation unless
+// variations were added or retired while the experiment was running.
+//
+// Reward tags are signed if bandit-api runs with a keyring, in which case
+// the job verifies them with -keyring. Rewards with invalid signatures are
+// skipped and counted.
+//
+// Segments of an experiment are named experiment-name@segment-name. Each
+// segment is aggregated by its own job, with -experiment-name set to the
+// segment's name.
+//
+// The collect and poll kinds emit version 2 snapshots: json with the number
+// of selects, the reward sum and the sum of squared rewards of each
+// variation, along with the experiment name, the generation time and the
+// version of the job. Poll writes them to <experiment-name>.json.
+//
+// The bundle kind merges the snapshots of many experiments, given as
+// arguments, into a single bundle keyed by experiment name:
+//
+// bandit-job -kind bundle shape-20130822.json plants-20121111.json > bundle.json
 //
 package main
 
 import (
 	"flag"
+	"github.com/purzelrakete/bandit"
+	"io"
 	"log"
 	"os"
 )
 
 var (
 	jobExperimentName = flag.String("experiment-name", "default", "name of experiment")
-	jobKind           = flag.String("kind", "", "kind ∈ {map,reduce,poll}")
+	jobKind           = flag.String("kind", "", "kind ∈ {map,reduce,collect,poll,bundle}")
 	jobLogfile        = flag.String("log-file", "bandit-log.txt", "log file to read")
 	jobLogPoll        = flag.Duration("log-poll", 1e13, "produce snapshots with this fq")
+	jobKeyring        = flag.String("keyring", "", "keyring json to verify signed reward tags")
 )
 
 func init() {
@@ -32,7 +65,15 @@ func init() {
 }
 
 func main() {
-	stats := newStatistics(*jobExperimentName)
+	var keyring *bandit.Keyring
+	if *jobKeyring != "" {
+		var err error
+		if keyring, err = bandit.NewKeyring(bandit.NewOpener(*jobKeyring)); err != nil {
+			log.Fatalf("could not initialize keyring: %s", err.Error())
+		}
+	}
+
+	stats := newStatistics(*jobExperimentName, keyring)
 
 	switch *jobKind {
 	case "map":
@@ -41,12 +82,25 @@ func main() {
 		reducer(stats, os.Stdin, os.Stdout)()
 	case "collect":
 		collector(stats, os.Stdin, os.Stdout)()
+	case "bundle":
+		var snapshots []io.Reader
+		for _, name := range flag.Args() {
+			file, err := bandit.NewOpener(name).Open()
+			if err != nil {
+				log.Fatalf("could not open snapshot: %s", err.Error())
+			}
+
+			defer file.Close()
+			snapshots = append(snapshots, file)
+		}
+
+		bundler(snapshots, os.Stdout)()
 	case "poll":
-		if err := simple(stats, *jobLogfile, *jobLogPoll); err != nil {
+		if err := simple(*jobExperimentName, keyring, *jobLogfile, *jobLogPoll); err != nil {
 			log.Fatalf("could not start polling job: %s", err.Error())
 		}
 	case "":
-		log.Fatalf("please provide a job kind ∈ {map,reduce,poll}")
+		log.Fatalf("please provide a job kind ∈ {map,reduce,collect,poll,bundle}")
 	default:
 		log.Fatalf("unkown job kind: %s", *jobKind)
 	}
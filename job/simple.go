@@ -4,49 +4,59 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/purzelrakete/bandit"
+	"io/ioutil"
 	"log"
-	"os"
 	"strings"
 	"time"
 )
 
-// simple produces a snapshot every `poll` duration. FIXME: O(N) memory
-func simple(s *statistics, logFile string, poll time.Duration) error {
-	snapshotFile := s.experimentName + ".tsv"
+// simple produces a version 2 snapshot every `poll` duration. FIXME: O(N)
+// memory
+func simple(name string, keyring *bandit.Keyring, logFile string, poll time.Duration) error {
+	snapshotFile := name + ".json"
 	opener := bandit.NewOpener(logFile)
 	file, err := opener.Open()
 	if err != nil {
 		return fmt.Errorf("could not open logs: %s", err.Error())
 	}
 
-	defer file.Close()
+	file.Close()
 	go func() {
 		t := time.NewTicker(poll)
 		for _ = range t.C {
 			file, err := opener.Open()
 			if err != nil {
 				log.Printf("error opening log: %s", err.Error())
+				continue
 			}
 
+			// aggregate the whole log on every poll
+			s := newStatistics(name, keyring)
+
 			// map
 			rM, wM := file, new(bytes.Buffer)
 			m := mapper(s, rM, wM)
 			m()
+			file.Close()
 			mapped := wM.String()
 
 			// reduce
 			rR, wR := strings.NewReader(mapped), new(bytes.Buffer)
 			r := reducer(s, rR, wR)
 			r()
-			reduced := strings.TrimRight(wR.String(), "\n ")
-
-			snapshot, err := os.Create(snapshotFile)
-			if err != nil {
-				log.Printf("error creating snapshot file: %s", err.Error())
+			reduced := wR.String()
+			if !strings.Contains(reduced, banditSelection) {
+				continue // no selects yet
 			}
 
-			defer snapshot.Close()
-			snapshot.Write([]byte(reduced))
+			// collect
+			rC, wC := strings.NewReader(reduced), new(bytes.Buffer)
+			c := collector(newStatistics(name, keyring), rC, wC)
+			c()
+
+			if err := ioutil.WriteFile(snapshotFile, wC.Bytes(), 0644); err != nil {
+				log.Printf("error writing snapshot file: %s", err.Error())
+			}
 		}
 	}()
 
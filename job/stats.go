@@ -5,57 +5,124 @@ package main
 
 import (
 	"fmt"
+	"github.com/purzelrakete/bandit"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	banditSelection = "BanditSelection"
-	banditReward    = "BanditReward"
+	banditSelection  = "BanditSelection"
+	banditReward     = "BanditReward"
+	banditSquares    = "BanditSquares"
+	banditRegression = "BanditRegression"
+	featuresField    = "features="
 )
 
+// jobVersion is stamped into snapshots. Set it at build time with
+// -ldflags "-X main.jobVersion=1.2".
+var jobVersion = "dev"
+
+// logFields returns the fields of a log line of `kind` for experiment `name`,
+// as written by bandit.SelectionLine and bandit.RewardLine, e.g.
+// `1379069648 BanditReward shape-20130822:2 1.000000`. Fields are separated by
+// any whitespace, and anything logged before the timestamp is dropped. The
+// experiment name is matched exactly, segments are aggregated separately.
+// Features of contextual lines are dropped, see logFeatures.
+func logFields(line, kind, name string) ([]string, bool) {
+	var fields []string
+	for _, field := range strings.Fields(line) {
+		if !strings.HasPrefix(field, featuresField) {
+			fields = append(fields, field)
+		}
+	}
+
+	for i := 1; i < len(fields)-1; i++ {
+		if fields[i] == kind {
+			return fields[i-1:], strings.HasPrefix(fields[i+1], name+":")
+		}
+	}
+
+	return nil, false
+}
+
+// logFeatures returns the comma separated features of a contextual log line,
+// e.g. `1,0,0.5` for `features=1,0,0.5`.
+func logFeatures(line string) (string, bool) {
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, featuresField) {
+			return strings.TrimPrefix(field, featuresField), true
+		}
+	}
+
+	return "", false
+}
+
 // statistics contains all stats which should be computed
 type statistics struct {
 	experimentName string
 	stats          []stats
 }
 
-// newStatistics creates a new object with default statistics
-func newStatistics(experimentName string) *statistics {
+// newStatistics creates a new object with default statistics. If a keyring
+// is given, rewards without a valid signature are skipped.
+func newStatistics(experimentName string, keyring *bandit.Keyring) *statistics {
 	return &statistics{
 		experimentName: experimentName,
 		stats: []stats{
-			newSumRewards(experimentName),
+			newSumRewards(experimentName, keyring),
 			newCountSelects(experimentName),
+			newSumSquares(experimentName),
+			newSumRegressions(experimentName, keyring),
 		},
 	}
 }
 
-func (s *statistics) rewards() ([]int, []float64) {
-	rewards, ok := s.stats[0].result()
-	if !ok {
-		panic("no rewards")
-	}
+// invalidRewards returns the number of rewards skipped because of invalid
+// signatures.
+func (s *statistics) invalidRewards() int {
+	return s.stats[0].(*sumRewards).invalid
+}
 
+// snapshot returns the snapshot of all variations with selects, in ascending
+// order of variation ids. Variations without rewards have a reward sum of 0.
+func (s *statistics) snapshot(generated time.Time) bandit.Snapshot {
+	rewards, _ := s.stats[0].result()
 	selects, ok := s.stats[1].result()
 	if !ok {
 		panic("no selects")
 	}
 
-	if len(selects) != len(rewards) {
-		panic("rewards, selects have different number of arms")
+	squares, _ := s.stats[2].result()
+	regressions := s.stats[3].(*sumRegressions).regressions
+
+	var ids []int
+	for key := range selects {
+		ids = append(ids, key)
 	}
 
-	rCounts := make([]int, len(rewards))
-	rRewards := make([]float64, len(rewards))
-	for key := range rewards {
-		index := key - 1
-		rCounts[index] = int(selects[key])
-		rRewards[index] = rewards[key] / selects[key]
+	sort.Ints(ids)
+
+	snapshot := bandit.Snapshot{
+		Version:    bandit.SnapshotVersion,
+		Experiment: s.experimentName,
+		Generated:  generated.UTC(),
+		JobVersion: jobVersion,
 	}
 
-	return rCounts, rRewards
+	for _, key := range ids {
+		snapshot.Arms = append(snapshot.Arms, bandit.ArmSnapshot{
+			Tag:        fmt.Sprintf("%s:%d", s.experimentName, key),
+			Count:      int(selects[key]),
+			Sum:        rewards[key],
+			SumSquares: squares[key],
+			Regression: regression(regressions[key]),
+		})
+	}
+
+	return snapshot
 }
 
 // stats aggregates statistics from line based input
@@ -67,6 +134,12 @@ type stats interface {
 	getPrefix() string
 }
 
+// vectorStats aggregate a vector per arm rather than a single value. Their
+// reducers emit comma separated vectors.
+type vectorStats interface {
+	vectors() map[int][]float64
+}
+
 type countSelects struct {
 	selects        map[int]float64
 	prefix         string
@@ -87,11 +160,9 @@ func (c *countSelects) getPrefix() string {
 
 // mapLine to count selects from a log file
 func (c *countSelects) mapLine(line string) (string, string, bool) {
-	selection := banditSelection + "\t" + c.experimentName
-	selectionLen := 3
-	if strings.Index(line, selection) >= 0 {
-		fields := strings.Fields(line)
-		if len(fields) != selectionLen {
+	selectionLen := 3 // optionally followed by propensity and position
+	if fields, ok := logFields(line, banditSelection, c.experimentName); ok {
+		if len(fields) < selectionLen || len(fields) > selectionLen+2 {
 			log.Fatalf("line does not have %d fields: '%s'", selectionLen, line)
 		}
 
@@ -147,13 +218,16 @@ type sumRewards struct {
 	prefix         string
 	experimentName string
 	rewards        map[int]float64
+	keyring        *bandit.Keyring // verifies signed tags. nil if unsigned.
+	invalid        int             // number of rewards with invalid signatures
 }
 
-func newSumRewards(name string) stats {
+func newSumRewards(name string, keyring *bandit.Keyring) stats {
 	return &sumRewards{
 		prefix:         "BanditReward",
 		experimentName: name,
 		rewards:        make(map[int]float64),
+		keyring:        keyring,
 	}
 }
 
@@ -163,14 +237,20 @@ func (s *sumRewards) getPrefix() string {
 
 // mapLine mapper emmits a key, value for each Reward line in log file
 func (s *sumRewards) mapLine(line string) (string, string, bool) {
-	reward := banditReward + "\t" + s.experimentName
-	rewardLen := 4
-	if strings.Index(line, reward) >= 0 {
-		fields := strings.Fields(line)
-		if len(fields) != rewardLen {
+	rewardLen := 4 // optionally followed by position
+	if fields, ok := logFields(line, banditReward, s.experimentName); ok {
+		if len(fields) != rewardLen && len(fields) != rewardLen+1 {
 			log.Fatalf("line does not have %d fields: '%s'", rewardLen, line)
 		}
 
+		// forged rewards are counted, but not aggregated
+		if s.keyring != nil {
+			if _, err := s.keyring.VerifyTag(fields[2]); err != nil {
+				s.invalid++
+				return "", "", false
+			}
+		}
+
 		splittedString := strings.Split(fields[2], ":")
 		variation, err := strconv.ParseInt(splittedString[1], 10, 0)
 		if err != nil {
@@ -222,3 +302,208 @@ func (s *sumRewards) collect(line string) {
 		s.rewards[variation] = reward
 	}
 }
+
+// sumSquares sums squared rewards. It reduces the mapped reward lines of
+// sumRewards, and does not map lines itself.
+type sumSquares struct {
+	prefix         string
+	experimentName string
+	squares        map[int]float64
+}
+
+func newSumSquares(name string) stats {
+	return &sumSquares{
+		prefix:         banditSquares,
+		experimentName: name,
+		squares:        make(map[int]float64),
+	}
+}
+
+func (s *sumSquares) getPrefix() string {
+	return s.prefix
+}
+
+// mapLine maps nothing, squares are reduced from mapped rewards
+func (s *sumSquares) mapLine(line string) (string, string, bool) {
+	return "", "", false
+}
+
+// reduceLine sums up the squares of incoming rewards
+func (s *sumSquares) reduceLine(line string) {
+	if strings.Index(line, banditReward+"_") >= 0 {
+		preparedString := strings.Replace(line, "_", "\t", 1)
+		fields := strings.Fields(preparedString)
+		variation, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("non-integral arm on line '%s': %s", line, err.Error())
+		}
+
+		reward, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			log.Fatalf("non-float reward on line '%s': %s", line, err.Error())
+		}
+
+		s.squares[variation-1] += reward * reward
+	}
+}
+
+func (s *sumSquares) result() (map[int]float64, bool) {
+	if len(s.squares) > 0 {
+		return s.squares, true
+	}
+	return map[int]float64{}, false
+}
+
+func (s *sumSquares) collect(line string) {
+	if strings.Index(line, s.prefix) >= 0 {
+		fields := strings.Fields(line)
+		variation, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("non-integral arm on line '%s': %s", line, err.Error())
+		}
+		squares, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			log.Fatalf("non-float squares on line '%s': %s", line, err.Error())
+		}
+		s.squares[variation] = squares
+	}
+}
+
+// sumRegressions sums the regression statistics of rewards logged with
+// features x, extended by a bias term 1. Each arm's vector holds Σ reward·x,
+// followed by the rows of Σ x·xᵀ.
+type sumRegressions struct {
+	prefix         string
+	experimentName string
+	regressions    map[int][]float64
+	keyring        *bandit.Keyring // verifies signed tags. nil if unsigned.
+}
+
+func newSumRegressions(name string, keyring *bandit.Keyring) stats {
+	return &sumRegressions{
+		prefix:         banditRegression,
+		experimentName: name,
+		regressions:    make(map[int][]float64),
+		keyring:        keyring,
+	}
+}
+
+func (s *sumRegressions) getPrefix() string {
+	return s.prefix
+}
+
+// mapLine emits the reward followed by the features of contextual rewards
+func (s *sumRegressions) mapLine(line string) (string, string, bool) {
+	features, ok := logFeatures(line)
+	if !ok {
+		return "", "", false
+	}
+
+	fields, ok := logFields(line, banditReward, s.experimentName)
+	if !ok || len(fields) < 4 {
+		return "", "", false
+	}
+
+	if s.keyring != nil {
+		if _, err := s.keyring.VerifyTag(fields[2]); err != nil {
+			return "", "", false
+		}
+	}
+
+	splittedString := strings.Split(fields[2], ":")
+	variation, err := strconv.ParseInt(splittedString[1], 10, 0)
+	if err != nil {
+		log.Fatalf("invalid variation on line '%s': %s", line, err.Error())
+	}
+
+	return fmt.Sprintf("%s_%d", s.prefix, variation), fields[3] + "," + features, true
+}
+
+// reduceLine sums up the regression statistics of incoming rewards
+func (s *sumRegressions) reduceLine(line string) {
+	if strings.Index(line, s.prefix+"_") >= 0 {
+		preparedString := strings.Replace(line, "_", "\t", 1)
+		fields := strings.Fields(preparedString)
+		variation, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("non-integral arm on line '%s': %s", line, err.Error())
+		}
+
+		values := parseVector(line, fields[2])
+		reward, x := values[0], append(values[1:], 1)
+		d := len(x)
+
+		sums, ok := s.regressions[variation-1]
+		if !ok {
+			sums = make([]float64, d+d*d)
+			s.regressions[variation-1] = sums
+		}
+
+		if len(sums) != d+d*d {
+			log.Fatalf("features of varying dimensions on line '%s'", line)
+		}
+
+		for i, xi := range x {
+			sums[i] += reward * xi
+			for j, xj := range x {
+				sums[d+i*d+j] += xi * xj
+			}
+		}
+	}
+}
+
+// result is empty, regressions are emitted as vectors
+func (s *sumRegressions) result() (map[int]float64, bool) {
+	return map[int]float64{}, false
+}
+
+func (s *sumRegressions) vectors() map[int][]float64 {
+	return s.regressions
+}
+
+func (s *sumRegressions) collect(line string) {
+	if strings.Index(line, s.prefix) >= 0 {
+		fields := strings.Fields(line)
+		variation, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("non-integral arm on line '%s': %s", line, err.Error())
+		}
+
+		s.regressions[variation] = parseVector(line, fields[2])
+	}
+}
+
+// parseVector parses comma separated floats of the given line.
+func parseVector(line, vector string) []float64 {
+	var values []float64
+	for _, str := range strings.Split(vector, ",") {
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			log.Fatalf("non-float vector on line '%s': %s", line, err.Error())
+		}
+
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// regression returns the regression of summed statistics, or nil for arms
+// without contextual rewards.
+func regression(sums []float64) *bandit.Regression {
+	d := 0
+	for d+d*d < len(sums) {
+		d++
+	}
+
+	if d == 0 || d+d*d != len(sums) {
+		return nil
+	}
+
+	r := &bandit.Regression{Moments: sums[:d]}
+	for i := 0; i < d; i++ {
+		r.Gram = append(r.Gram, sums[d+i*d:d+(i+1)*d])
+	}
+
+	return r
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"bytes"
+	"github.com/purzelrakete/bandit"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -10,14 +15,21 @@ func TestMapper(t *testing.T) {
 	log := []string{
 		"1379069548	BanditSelection	shape-20130822:2:1",
 		"1379069749	BanditSelection	shape-20130822:2:1",
+		"1379069750	BanditSelection	shape-20130822:2:1	0.500000",
+		"1379069751	BanditSelection	shape-20130822:2:1	0.000000	2",
 		"1379069948	BanditSelection	plants-20121111:1:2",
+		"1379069949	BanditSelection	shape-20130822@de:1:2	0.500000",
+		"1379069950	BanditNotEnrolled	shape-20130822:2:1	1.000000",
+		"1379069951	BanditOverride	shape-20130822:2:1	1.000000",
 		"1379069648	BanditReward	shape-20130822:2:1 1.0",
 		"1379069848	BanditReward	shape-20130822:2:1 0.0",
+		"1379069849	BanditReward	shape-20130822:2:1 1.0	2",
 		"1379069158	BanditReward	plants-20121111:1:2 1.0",
 		"1379069258	BanditReward	plants-20121111:1:2 1.0",
+		"1379069259	BanditReward	shape-20130822@de:1:2 1.0",
 	}
 
-	stats := newStatistics("shape-20130822")
+	stats := newStatistics("shape-20130822", nil)
 
 	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
 	mapper := mapper(stats, r, w)
@@ -26,10 +38,13 @@ func TestMapper(t *testing.T) {
 	mapped := strings.TrimRight(w.String(), "\n ")
 
 	expected := strings.Join([]string{
+		"BanditSelection_2	1",
+		"BanditSelection_2	1",
 		"BanditSelection_2	1",
 		"BanditSelection_2	1",
 		"BanditReward_2	1.0",
 		"BanditReward_2	0.0",
+		"BanditReward_2	1.0",
 	}, "\n")
 
 	if got := mapped; got != expected {
@@ -37,6 +52,155 @@ func TestMapper(t *testing.T) {
 	}
 }
 
+func TestMapperSegment(t *testing.T) {
+	log := []string{
+		"1379069548	BanditSelection	shape-20130822:2:1",
+		"1379069549	BanditSelection	shape-20130822@de:1:1	0.500000",
+		"1379069648	BanditReward	shape-20130822:2:1 1.0",
+		"1379069649	BanditReward	shape-20130822@de:1:1 1.0",
+	}
+
+	stats := newStatistics("shape-20130822@de", nil)
+
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+	mapper := mapper(stats, r, w)
+
+	mapper()
+	mapped := strings.TrimRight(w.String(), "\n ")
+
+	expected := strings.Join([]string{
+		"BanditSelection_1	1",
+		"BanditReward_1	1.0",
+	}, "\n")
+
+	if got := mapped; got != expected {
+		t.Fatalf("expected '%s' but got '%s'", expected, got)
+	}
+}
+
+func TestMapperSigned(t *testing.T) {
+	file, err := ioutil.TempFile("", "keyring")
+	if err != nil {
+		t.Fatalf("could not create keyring: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{"current": "k1", "keys": {"k1": "s3cr3t"}}`)
+	file.Close()
+
+	keyring, err := bandit.NewKeyring(bandit.NewFileOpener(file.Name()))
+	if err != nil {
+		t.Fatalf("could not make keyring: %s", err.Error())
+	}
+
+	log := []string{
+		"1379069648	BanditReward	" + keyring.SignTag("shape-20130822:2:1") + "	1.0",
+		"1379069649	BanditReward	shape-20130822:1:1:forged	1.0",
+	}
+
+	stats := newStatistics("shape-20130822", keyring)
+
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+	mapper := mapper(stats, r, w)
+
+	mapper()
+	mapped := strings.TrimRight(w.String(), "\n ")
+
+	if expected := "BanditReward_2	1.0"; mapped != expected {
+		t.Fatalf("expected '%s' but got '%s'", expected, mapped)
+	}
+
+	if got := stats.invalidRewards(); got != 1 {
+		t.Fatalf("expected 1 invalid reward, got %d", got)
+	}
+}
+
+func TestMapperRewardLine(t *testing.T) {
+	file, err := ioutil.TempFile("", "keyring")
+	if err != nil {
+		t.Fatalf("could not create keyring: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{"current": "k1", "keys": {"k1": "s3cr3t"}}`)
+	file.Close()
+
+	keyring, err := bandit.NewKeyring(bandit.NewFileOpener(file.Name()))
+	if err != nil {
+		t.Fatalf("could not make keyring: %s", err.Error())
+	}
+
+	signed := bandit.Variation{Tag: keyring.SignTag("shape-20130822:2:1379069648")}
+	unsigned := bandit.Variation{Tag: "shape-20130822:1"}
+	log := []string{
+		bandit.SelectionLine(nil, bandit.Selection{Variation: unsigned, Propensity: 0.5}),
+		bandit.RewardLine(nil, signed, 1),
+		"2013/09/13 10:00:00 " + bandit.PositionRewardLine(nil, signed, 2, 0.5),
+		bandit.RewardLine(nil, unsigned, 1),
+	}
+
+	stats := newStatistics("shape-20130822", keyring)
+
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+	mapper := mapper(stats, r, w)
+
+	mapper()
+	mapped := strings.TrimRight(w.String(), "\n ")
+
+	expected := strings.Join([]string{
+		"BanditSelection_1	1",
+		"BanditReward_2	1.000000",
+		"BanditReward_2	0.500000",
+	}, "\n")
+
+	if got := mapped; got != expected {
+		t.Fatalf("expected '%s' but got '%s'", expected, got)
+	}
+
+	if got := stats.invalidRewards(); got != 1 {
+		t.Fatalf("expected unsigned reward to be invalid, got %d", got)
+	}
+}
+
+func TestRegressions(t *testing.T) {
+	v1, v2 := bandit.Variation{Tag: "shape-20130822:1:1"}, bandit.Variation{Tag: "shape-20130822:2:1"}
+	log := []string{
+		bandit.SelectionLine(nil, bandit.Selection{Variation: v1, Propensity: 1, Features: []float64{1, 0}}),
+		bandit.SelectionLine(nil, bandit.Selection{Variation: v2, Propensity: 1, Features: []float64{1, 0}}),
+		bandit.SelectionLine(nil, bandit.Selection{Variation: v2, Propensity: 1, Features: []float64{0, 1}}),
+		bandit.RewardLine(nil, v1, 1),
+		bandit.ContextRewardLine(nil, v2, []float64{1, 0}, 1),
+		bandit.ContextRewardLine(nil, v2, []float64{0, 1}, 0),
+	}
+
+	stats := newStatistics("shape-20130822", nil)
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+	mapper(stats, r, w)()
+
+	r, w = strings.NewReader(w.String()), new(bytes.Buffer)
+	reducer(stats, r, w)()
+
+	r, w = strings.NewReader(w.String()), new(bytes.Buffer)
+	collector(newStatistics("shape-20130822", nil), r, w)()
+
+	snapshot, err := bandit.DecodeSnapshot(w)
+	if err != nil {
+		t.Fatalf("could not decode snapshot: %s", err.Error())
+	}
+
+	expected := []bandit.ArmSnapshot{
+		{Tag: "shape-20130822:1", Count: 1, Sum: 1, SumSquares: 1},
+		{Tag: "shape-20130822:2", Count: 2, Sum: 1, SumSquares: 1, Regression: &bandit.Regression{
+			Gram:    [][]float64{{1, 0, 1}, {0, 1, 1}, {1, 1, 2}},
+			Moments: []float64{1, 0, 1},
+		}},
+	}
+
+	if got := snapshot.Arms; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected '%v' but got '%v'", expected, got)
+	}
+}
+
 func TestReducer(t *testing.T) {
 	log := []string{
 		"BanditSelection_1	1",
@@ -47,7 +211,7 @@ func TestReducer(t *testing.T) {
 		"BanditReward_1	0.0",
 	}
 
-	stats := newStatistics("shape-20130822")
+	stats := newStatistics("shape-20130822", nil)
 
 	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
 	reducer := reducer(stats, r, w)
@@ -59,6 +223,7 @@ func TestReducer(t *testing.T) {
 		"BanditReward	1	1.000000",
 		"BanditSelection	1	2.000000",
 		"BanditSelection	2	2.000000",
+		"BanditSquares	1	1.000000",
 	}, "\n")
 
 	if got := reduced; got != expected {
@@ -77,7 +242,7 @@ func TestMapperReducer(t *testing.T) {
 		"1379069258	BanditReward	plants-20121111:1:3	1.0",
 	}
 
-	stats := newStatistics("shape-20130822")
+	stats := newStatistics("shape-20130822", nil)
 
 	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
 	mapper := mapper(stats, r, w)
@@ -95,6 +260,7 @@ func TestMapperReducer(t *testing.T) {
 	expected := strings.Join([]string{
 		"BanditReward	2	1.000000",
 		"BanditSelection	2	2.000000",
+		"BanditSquares	2	1.000000",
 	}, "\n")
 
 	if got := reduced; got != expected {
@@ -106,43 +272,147 @@ func TestCollect(t *testing.T) {
 	log := []string{
 		"BanditReward	2	1.000000",
 		"BanditSelection	2	2.000000",
+		"BanditSquares	2	1.000000",
 		"BanditReward	1	2.000000",
 		"BanditSelection	1	4.000000",
+		"BanditSquares	1	2.000000",
 	}
 
-	stats := newStatistics("shape-20130822")
+	stats := newStatistics("shape-20130822", nil)
 
 	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
 	collect := collector(stats, r, w)
 	collect()
-	collected := strings.TrimRight(w.String(), "\n ")
 
-	expected := "2	0.500000	0.500000"
+	snapshot, err := bandit.DecodeSnapshot(w)
+	if err != nil {
+		t.Fatalf("could not decode snapshot: %s", err.Error())
+	}
 
-	if got := collected; got != expected {
-		t.Fatalf("expected '%s' but got '%s'", expected, got)
+	if snapshot.Experiment != "shape-20130822" || snapshot.JobVersion != jobVersion {
+		t.Fatalf("unexpected metadata %v", snapshot)
+	}
+
+	if snapshot.Generated.IsZero() {
+		t.Fatalf("expected generation time")
+	}
+
+	expected := []bandit.ArmSnapshot{
+		{Tag: "shape-20130822:1", Count: 4, Sum: 2, SumSquares: 2},
+		{Tag: "shape-20130822:2", Count: 2, Sum: 1, SumSquares: 1},
+	}
+
+	if got := snapshot.Arms; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected '%v' but got '%v'", expected, got)
 	}
 }
 
-func TestSnapshotCounter(t *testing.T) {
+func TestCollectRetired(t *testing.T) {
+	log := []string{
+		"BanditReward	3	1.000000",
+		"BanditSelection	3	2.000000",
+		"BanditSquares	3	1.000000",
+		"BanditSelection	1	4.000000",
+	}
+
+	stats := newStatistics("shape-20130822", nil)
+
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+	collect := collector(stats, r, w)
+	collect()
+
+	snapshot, err := bandit.DecodeSnapshot(w)
+	if err != nil {
+		t.Fatalf("could not decode snapshot: %s", err.Error())
+	}
+
+	expected := []bandit.ArmSnapshot{
+		{Tag: "shape-20130822:1", Count: 4},
+		{Tag: "shape-20130822:3", Count: 2, Sum: 1, SumSquares: 1},
+	}
+
+	if got := snapshot.Arms; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestSnapshotCounters(t *testing.T) {
 	log := []string{
 		"BanditReward	2	1.000000",
 		"BanditSelection	2	4.000000",
+		"BanditSquares	2	1.000000",
 		"BanditReward	1	2.000000",
 		"BanditSelection	1	4.000000",
+		"BanditSquares	1	2.000000",
 	}
 
-	stats := newStatistics("shape-20130822")
+	stats := newStatistics("shape-20130822", nil)
 	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
 
 	collect := collector(stats, r, w)
 	collect()
-	counts, rewards := stats.rewards()
 
-	expected := "2	0.500000	0.250000"
-	snapshot := tsvSnapshot(counts, rewards)
+	counters, err := bandit.ParseSnapshot(w)
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
 
-	if got := snapshot; got != expected {
-		t.Fatalf("expected '%s' but got '%s'", expected, got)
+	analysis := bandit.Analyze(counters)
+	if len(analysis) != 2 || analysis[0].Mean != 0.5 || analysis[1].Mean != 0.25 {
+		t.Fatalf("unexpected mean rewards %v", analysis)
+	}
+
+	if analysis[0].Pulls != 4 || analysis[1].Pulls != 4 {
+		t.Fatalf("unexpected pulls %v", analysis)
+	}
+}
+
+func TestSnapshotWithoutRewards(t *testing.T) {
+	log := []string{
+		"BanditSelection	1	4.000000",
+		"BanditSelection	2	3.000000",
+	}
+
+	stats := newStatistics("shape-20130822", nil)
+	r, w := strings.NewReader(strings.Join(log, "\n")), new(bytes.Buffer)
+
+	collect := collector(stats, r, w)
+	collect()
+
+	snapshot, err := bandit.DecodeSnapshot(w)
+	if err != nil {
+		t.Fatalf("could not decode snapshot: %s", err.Error())
+	}
+
+	expected := []bandit.ArmSnapshot{
+		{Tag: "shape-20130822:1", Count: 4},
+		{Tag: "shape-20130822:2", Count: 3},
+	}
+
+	if got := snapshot.Arms; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestBundler(t *testing.T) {
+	snapshots := []io.Reader{
+		strings.NewReader(`{"version": 2, "experiment": "shape", "arms": [{"tag": "shape:1", "count": 4, "sum": 2}]}`),
+		strings.NewReader(`{"version": 2, "experiment": "fonts", "arms": [{"tag": "fonts:1", "count": 2, "sum": 1}]}`),
+	}
+
+	w := new(bytes.Buffer)
+	bundler(snapshots, w)()
+
+	bundle, err := bandit.DecodeBundle(w)
+	if err != nil {
+		t.Fatalf("could not decode bundle: %s", err.Error())
+	}
+
+	if got := len(bundle.Snapshots); got != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", got)
+	}
+
+	if got := bundle.Snapshots["fonts"].Arms[0].Count; got != 2 {
+		t.Fatalf("expected 2 pulls of fonts:1, got %d", got)
 	}
 }
@@ -0,0 +1,157 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// NewKeyring reads a keyring of server secrets from json:
+//
+//     {"current": "2013-09", "keys": {"2013-09": "s3cr3t", "2013-08": "0ld"}}
+//
+// Messages are signed with the current key and verified with any key. Keys
+// are rotated by adding a new key, making it current, and removing the
+// previous key once all messages signed with it have expired.
+func NewKeyring(o Opener) (*Keyring, error) {
+	file, err := o.Open()
+	if err != nil {
+		return &Keyring{}, fmt.Errorf("need a valid keyring: %v", err)
+	}
+
+	defer file.Close()
+
+	jsonString, err := ioutil.ReadAll(file)
+	if err != nil {
+		return &Keyring{}, fmt.Errorf("could not read keyring: %s", err.Error())
+	}
+
+	var cfg struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+
+	if err := json.Unmarshal(jsonString, &cfg); err != nil {
+		return &Keyring{}, fmt.Errorf("could not marshal keyring: %s", err.Error())
+	}
+
+	keys := make([][]byte, 0, len(cfg.Keys))
+	current, ok := cfg.Keys[cfg.Current]
+	if !ok || current == "" {
+		return &Keyring{}, fmt.Errorf("current key '%s' not in keyring", cfg.Current)
+	}
+
+	keys = append(keys, []byte(current))
+	for id, key := range cfg.Keys {
+		if key == "" {
+			return &Keyring{}, fmt.Errorf("key '%s' is blank", id)
+		}
+
+		if id != cfg.Current {
+			keys = append(keys, []byte(key))
+		}
+	}
+
+	return &Keyring{keys: keys}, nil
+}
+
+// Keyring signs and verifies messages with HMAC-SHA256.
+type Keyring struct {
+	keys [][]byte // the current key first
+}
+
+// Purposes of signed messages. The purpose is signed along with the message,
+// so that signatures of one purpose are invalid for any other.
+const (
+	purposeReward   = "reward"
+	purposeOverride = "override"
+)
+
+// SignTag returns the timestamped tag signed with the current key, in the
+// form <timestampedTag>:<signature>. Signed tags are rewarded and pinned.
+func (k *Keyring) SignTag(timestampedTag string) string {
+	return k.sign(purposeReward, timestampedTag)
+}
+
+// VerifyTag returns the timestamped tag of a signed tag, or an error if no
+// key of the keyring signed it as a tag.
+func (k *Keyring) VerifyTag(signed string) (string, error) {
+	return k.verify(purposeReward, signed)
+}
+
+// SignOverride returns an override of the variation tag which expires at
+// `expires`, signed with the current key, in the form
+// <tag>:<expiry>:<signature>.
+func (k *Keyring) SignOverride(tag string, expires time.Time) string {
+	return k.sign(purposeOverride, fmt.Sprintf("%s:%d", tag, expires.Unix()))
+}
+
+// VerifyOverride returns the variation tag of a signed override, or an error
+// if no key of the keyring signed it as an override, or if it expired before
+// `now`.
+func (k *Keyring) VerifyOverride(signed string, now time.Time) (string, error) {
+	override, err := k.verify(purposeOverride, signed)
+	if err != nil {
+		return "", err
+	}
+
+	tag, expires, err := TimestampedTagToTag(override)
+	if err != nil {
+		return "", fmt.Errorf("invalid override: %s", err.Error())
+	}
+
+	if now.After(time.Unix(expires, 0)) {
+		return "", fmt.Errorf("override of %s expired", tag)
+	}
+
+	return tag, nil
+}
+
+// sign returns the message signed for `purpose` with the current key, in the
+// form <message>:<signature>.
+func (k *Keyring) sign(purpose, message string) string {
+	return fmt.Sprintf("%s:%s", message, signature(k.keys[0], purpose+":"+message))
+}
+
+// verify returns the message of a message signed for `purpose`, or an error
+// if no key of the keyring signed it.
+func (k *Keyring) verify(purpose, signed string) (string, error) {
+	sep := strings.LastIndex(signed, ":")
+	if sep == -1 {
+		return "", fmt.Errorf("invalid signed message, does not end in :<signature>")
+	}
+
+	message, mac := signed[:sep], signed[sep+1:]
+	for _, key := range k.keys {
+		if hmac.Equal([]byte(mac), []byte(signature(key, purpose+":"+message))) {
+			return message, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid signature for '%s'", message)
+}
+
+// TimestampedTagToTag is TimestampedTagToTag for signed timestamped tags.
+func (k *Keyring) TimestampedTagToTag(signed string) (string, int64, error) {
+	timestampedTag, err := k.VerifyTag(signed)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return TimestampedTagToTag(timestampedTag)
+}
+
+// signature is the hex encoded HMAC-SHA256 of the message.
+func signature(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyring(t *testing.T) {
+	previous, err := NewKeyring(stringOpener(`{"current": "k1", "keys": {"k1": "s3cr3t"}}`))
+	if err != nil {
+		t.Fatalf("could not make keyring: %s", err.Error())
+	}
+
+	rotated, err := NewKeyring(stringOpener(`{"current": "k2", "keys": {"k1": "s3cr3t", "k2": "n3w"}}`))
+	if err != nil {
+		t.Fatalf("could not make keyring: %s", err.Error())
+	}
+
+	signed := previous.SignTag("shape:2:1378823906")
+	tag, ts, err := rotated.TimestampedTagToTag(signed)
+	if err != nil {
+		t.Fatalf("could not verify tag signed with previous key: %s", err.Error())
+	}
+
+	if tag != "shape:2" || ts != 1378823906 {
+		t.Fatalf("expected shape:2 at 1378823906, got %s at %d", tag, ts)
+	}
+
+	if _, err := previous.VerifyTag(rotated.SignTag("shape:2:1378823906")); err == nil {
+		t.Fatalf("expected tag signed with unknown key to be rejected")
+	}
+
+	if _, err := rotated.VerifyTag(strings.Replace(signed, "shape:2", "shape:1", 1)); err == nil {
+		t.Fatalf("expected forged tag to be rejected")
+	}
+
+	now := time.Now()
+	override := rotated.SignOverride("shape:2", now.Add(time.Hour))
+	if tag, err := rotated.VerifyOverride(override, now); err != nil || tag != "shape:2" {
+		t.Fatalf("could not verify override: %v", err)
+	}
+
+	if _, err := rotated.VerifyOverride(override, now.Add(2*time.Hour)); err == nil {
+		t.Fatalf("expected expired override to be rejected")
+	}
+
+	if _, err := rotated.VerifyTag(override); err == nil {
+		t.Fatalf("expected override to be rejected as a tag")
+	}
+
+	if _, err := rotated.VerifyOverride(rotated.SignTag("shape:2:1378823906"), now); err == nil {
+		t.Fatalf("expected tag to be rejected as an override")
+	}
+
+	if _, err := NewKeyring(stringOpener(`{"current": "k3", "keys": {"k1": "s3cr3t"}}`)); err == nil {
+		t.Fatalf("expected keyring without current key to be rejected")
+	}
+}
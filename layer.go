@@ -0,0 +1,136 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"sort"
+)
+
+// layerBuckets is the number of traffic buckets in each layer.
+const layerBuckets = 1000
+
+// Buckets is the range [from, to) of the buckets of a layer claimed by an
+// experiment.
+type Buckets [2]int
+
+// Contains returns true if the bucket is in the range.
+func (b Buckets) Contains(bucket int) bool {
+	return bucket >= b[0] && bucket < b[1]
+}
+
+// Overlaps returns true if both ranges share a bucket.
+func (b Buckets) Overlaps(other Buckets) bool {
+	return b[0] < other[1] && other[0] < b[1]
+}
+
+// bucket returns the bucket of the uid in the layer. Uids are hashed with the
+// layer name, so that buckets of different layers are independent.
+func bucket(layer, uid string) int {
+	return int(stickyHash(layer, uid) * layerBuckets)
+}
+
+// claims returns true if the experiment serves the uid. Experiments outside
+// layers serve all uids. Layered experiments do not serve blank uids, which
+// cannot be kept out of the other experiments of the layer.
+func (e *Experiment) claims(uid string) bool {
+	if e.Layer == "" {
+		return true
+	}
+
+	if uid == "" {
+		return false
+	}
+
+	return e.Buckets.Contains(bucket(e.Layer, uid))
+}
+
+// Assignment is the variation selected for a user in a layer.
+type Assignment struct {
+	Layer      string
+	Experiment *Experiment
+	Selection
+}
+
+// SelectAll selects the variation of the uid in every layer, in order of
+// layer name. Experiments outside layers form layers of their own, named
+// after the experiment. Layers where the uid falls into unclaimed buckets are
+// left out.
+func (es *Experiments) SelectAll(uid string) ([]Assignment, error) {
+	return es.SelectAllRequest(Request{UID: uid})
+}
+
+// SelectAllRequest is SelectAll for requests with features and attributes.
+// The request must have a uid.
+func (es *Experiments) SelectAllRequest(r Request) ([]Assignment, error) {
+	if r.UID == "" {
+		return nil, fmt.Errorf("cannot select layers without uid")
+	}
+
+	layers := es.layers()
+
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var assignments []Assignment
+	for _, name := range names {
+		for _, e := range layers[name] {
+			if !e.claims(r.UID) {
+				continue
+			}
+
+			selected, err := e.SelectRequest(r)
+			if err != nil {
+				return nil, err
+			}
+
+			assignments = append(assignments, Assignment{
+				Layer:      name,
+				Experiment: e,
+				Selection:  selected,
+			})
+		}
+	}
+
+	return assignments, nil
+}
+
+// layers returns the experiments of each layer.
+func (es *Experiments) layers() map[string][]*Experiment {
+	layers := make(map[string][]*Experiment)
+	for name, e := range *es {
+		layer := e.Layer
+		if layer == "" {
+			layer = name
+		}
+
+		layers[layer] = append(layers[layer], e)
+	}
+
+	return layers
+}
+
+// checkLayers returns an error if bucket ranges are invalid, or if
+// experiments in the same layer claim the same bucket.
+func (es *Experiments) checkLayers() error {
+	for layer, experiments := range es.layers() {
+		for i, e := range experiments {
+			if b := e.Buckets; b[0] < 0 || b[0] >= b[1] || b[1] > layerBuckets {
+				return fmt.Errorf("%s: buckets %v not in [0, %d)", e.Name, b, layerBuckets)
+			}
+
+			for _, other := range experiments[:i] {
+				if e.Buckets.Overlaps(other.Buckets) {
+					return fmt.Errorf("%s and %s overlap in layer %s", e.Name, other.Name, layer)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSelectAll(t *testing.T) {
+	config := stringOpener(`[
+		{
+			"experiment_name": "buttons",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"layer": "checkout",
+			"buckets": [0, 500],
+			"variations": [{"url": "red", "ordinal": 1}, {"url": "blue", "ordinal": 2}]
+		},
+		{
+			"experiment_name": "forms",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"layer": "checkout",
+			"buckets": [500, 1000],
+			"variations": [{"url": "short", "ordinal": 1}, {"url": "long", "ordinal": 2}]
+		},
+		{
+			"experiment_name": "fonts",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"variations": [{"url": "serif", "ordinal": 1}, {"url": "sans", "ordinal": 2}]
+		}
+	]`)
+
+	es, err := NewExperiments(config)
+	if err != nil {
+		t.Fatalf("could not make experiments: %s", err.Error())
+	}
+
+	served := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		uid := fmt.Sprintf("%d", i)
+		assignments, err := es.SelectAll(uid)
+		if err != nil {
+			t.Fatalf("could not select layers: %s", err.Error())
+		}
+
+		if len(assignments) != 2 {
+			t.Fatalf("expected one assignment per layer, got %d", len(assignments))
+		}
+
+		if got := assignments[0].Layer; got != "checkout" {
+			t.Fatalf("expected checkout layer first, got %s", got)
+		}
+
+		name := assignments[0].Experiment.Name
+		served[name]++
+
+		other := "forms"
+		if name == "forms" {
+			other = "buttons"
+		}
+
+		s, err := (*es)[other].SelectRequest(Request{UID: uid})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if !s.Excluded {
+			t.Fatalf("expected uid %s to be excluded from %s", uid, other)
+		}
+	}
+
+	if served["buttons"] == 0 || served["forms"] == 0 {
+		t.Fatalf("expected uids to be spread over the layer, got %v", served)
+	}
+
+	for _, name := range []string{"buttons", "forms"} {
+		s, err := (*es)[name].SelectRequest(Request{})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if !s.Excluded || s.Ordinal != 1 {
+			t.Fatalf("expected blank uid to get the preferred variation of %s, got %v", name, s)
+		}
+	}
+}
+
+func TestLayerOverlap(t *testing.T) {
+	config := stringOpener(`[
+		{
+			"experiment_name": "buttons",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"layer": "checkout",
+			"buckets": [0, 600],
+			"variations": [{"url": "red", "ordinal": 1}]
+		},
+		{
+			"experiment_name": "forms",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"layer": "checkout",
+			"buckets": [500, 1000],
+			"variations": [{"url": "short", "ordinal": 1}]
+		}
+	]`)
+
+	if _, err := NewExperiments(config); err == nil {
+		t.Fatalf("expected overlapping experiments to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is the lifecycle state of an experiment. Only running experiments
+// explore. All other experiments serve the preferred variation, which is the
+// winner once it is declared.
+type State string
+
+const (
+	// Draft experiments are being set up. They start running at their
+	// scheduled start, if any.
+	Draft State = "draft"
+
+	// Running experiments select variations with their strategy.
+	Running State = "running"
+
+	// Paused experiments can be resumed.
+	Paused State = "paused"
+
+	// Concluded experiments are over for good.
+	Concluded State = "concluded"
+)
+
+// transitions are the valid transitions between states. Concluded
+// experiments cannot be restarted.
+var transitions = map[State][]State{
+	Draft:   {Running, Concluded},
+	Running: {Paused, Concluded},
+	Paused:  {Running, Concluded},
+}
+
+// validTransition returns true if an experiment can move from one state to
+// another.
+func validTransition(from, to State) bool {
+	for _, state := range transitions[from] {
+		if state == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSchedule returns an error if the state is unknown, or if the schedule
+// implies an invalid transition. A start time moves a draft experiment to
+// running, an end time moves any experiment to concluded.
+func checkSchedule(state State, start, end time.Time) error {
+	if _, ok := transitions[state]; !ok && state != Concluded {
+		return fmt.Errorf("unknown state '%s'", state)
+	}
+
+	if !start.IsZero() && state != Draft {
+		return fmt.Errorf("cannot start %s experiment", state)
+	}
+
+	if !end.IsZero() && !validTransition(state, Concluded) {
+		return fmt.Errorf("cannot conclude %s experiment", state)
+	}
+
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return fmt.Errorf("start %s is not before end %s", start, end)
+	}
+
+	return nil
+}
+
+// Status returns the current state of the experiment, given its schedule.
+func (e *Experiment) Status() State {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.stateAt(time.Now())
+}
+
+// Transition moves the experiment and its segments into another state.
+// Returns an error if the transition is invalid.
+func (e *Experiment) Transition(to State) error {
+	experiments := e.experiments()
+	for _, x := range experiments {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+	}
+
+	now := time.Now()
+	if from := e.stateAt(now); !validTransition(from, to) {
+		return fmt.Errorf("%s: cannot transition from %s to %s", e.Name, from, to)
+	}
+
+	for _, x := range experiments {
+		x.State = to
+	}
+
+	return nil
+}
+
+// stateAt returns the state of the experiment at time `now`, given its
+// schedule. Experiments without a state are running.
+func (e *Experiment) stateAt(now time.Time) State {
+	state := e.State
+	if state == "" {
+		state = Running
+	}
+
+	switch {
+	case state == Concluded:
+	case !e.End.IsZero() && !now.Before(e.End):
+		return Concluded
+	case state == Draft && !e.Start.IsZero() && !now.Before(e.Start):
+		return Running
+	}
+
+	return state
+}
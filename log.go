@@ -5,22 +5,46 @@ package bandit
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	banditSelection = "BanditSelection"
-	banditReward    = "BanditReward"
+	banditSelection   = "BanditSelection"
+	banditReward      = "BanditReward"
+	banditNotEnrolled = "BanditNotEnrolled"
+	banditOverride    = "BanditOverride"
 )
 
 // SelectionLine captures all selected arms. This log can be used in conjunction
-// with reward logs to fully rebuild strategys.
-func SelectionLine(experiment Experiment, selected Variation) string {
+// with reward logs to fully rebuild strategys. The propensity of the selection
+// is included for off-policy evaluation, followed by the position for slates,
+// and by the features of contextual selections, e.g. `features=1,0,0.5`.
+// Users outside the allocation are logged as not enrolled, and forced
+// variations as overrides, so that they are not counted as selections.
+func SelectionLine(experiment *Experiment, selected Selection) string {
+	kind := banditSelection
+	switch {
+	case selected.Override:
+		kind = banditOverride
+	case selected.NotEnrolled:
+		kind = banditNotEnrolled
+	}
+
 	record := []string{
 		fmt.Sprintf("%d", time.Now().Unix()),
-		banditSelection,
+		kind,
 		selected.Tag,
+		fmt.Sprintf("%f", selected.Propensity),
+	}
+
+	if selected.Position > 0 {
+		record = append(record, fmt.Sprintf("%d", selected.Position))
+	}
+
+	if selected.Features != nil {
+		record = append(record, featuresField(selected.Features))
 	}
 
 	return strings.Join(record, " ")
@@ -28,7 +52,7 @@ func SelectionLine(experiment Experiment, selected Variation) string {
 
 // RewardLine captures all selected arms. This log can be used in conjunction
 // with reward logs to fully rebuild strategys.
-func RewardLine(experiment Experiment, selected Variation, reward float64) string {
+func RewardLine(experiment *Experiment, selected Variation, reward float64) string {
 	record := []string{
 		fmt.Sprintf("%d", time.Now().Unix()),
 		banditReward,
@@ -38,3 +62,25 @@ func RewardLine(experiment Experiment, selected Variation, reward float64) strin
 
 	return strings.Join(record, " ")
 }
+
+// PositionRewardLine is RewardLine for a variation shown at the given 1
+// indexed position of a slate.
+func PositionRewardLine(experiment *Experiment, selected Variation, position int, reward float64) string {
+	return fmt.Sprintf("%s %d", RewardLine(experiment, selected, reward), position)
+}
+
+// ContextRewardLine is RewardLine for a variation selected with features.
+// The features are logged, so that contextual strategies can be rebuilt.
+func ContextRewardLine(experiment *Experiment, selected Variation, features []float64, reward float64) string {
+	return fmt.Sprintf("%s %s", RewardLine(experiment, selected, reward), featuresField(features))
+}
+
+// featuresField formats features as a log field, e.g. `features=1,0,0.5`.
+func featuresField(features []float64) string {
+	values := make([]string, len(features))
+	for i, feature := range features {
+		values[i] = strconv.FormatFloat(feature, 'f', -1, 64)
+	}
+
+	return "features=" + strings.Join(values, ",")
+}
@@ -0,0 +1,56 @@
+package math
+
+import (
+	"math"
+	"math/rand"
+)
+
+// A GammaRand is a source of gamma distributed random values.
+type GammaRand struct {
+	rand *rand.Rand // seeded random number generator to generate other random values.
+}
+
+// NewGammaRand returns a new GammaRand that uses random values from rand to
+// generate gamma random values.
+func NewGammaRand(seed int64) *GammaRand {
+	return NewGammaRandSource(rand.NewSource(seed))
+}
+
+// NewGammaRandSource returns a new GammaRand that draws from `src`.
+func NewGammaRandSource(src rand.Source) *GammaRand {
+	return &GammaRand{rand.New(src)}
+}
+
+// NextGamma returns gamma distributed random variables: x ~ Gamma(α, β) with
+// shape α and rate β. implementation follows G. Marsaglia, W. Tsang: A Simple
+// Method for Generating Gamma Variables
+func (r *GammaRand) NextGamma(α, β float64) float64 {
+	// boost shapes below 1: Gamma(α) = Gamma(α+1) * U^(1/α)
+	if α < 1 {
+		return r.NextGamma(α+1, β) * math.Pow(r.rand.Float64(), 1/α)
+	}
+
+	d := α - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := r.rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+
+		v = v * v * v
+		u := r.rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v / β
+		}
+	}
+}
+
+// NextNormalGamma returns the mean of normal gamma distributed random
+// variables: (x, τ) ~ NormalGamma(μ, κ, α, β), where x ~ N(μ, 1/(κτ)) and
+// τ ~ Gamma(α, β).
+func (r *GammaRand) NextNormalGamma(μ, κ, α, β float64) float64 {
+	τ := r.NextGamma(α, β)
+	return μ + r.rand.NormFloat64()/math.Sqrt(κ*τ)
+}
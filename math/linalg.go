@@ -0,0 +1,97 @@
+package math
+
+import (
+	"math"
+)
+
+// Identity returns the n×n identity matrix.
+func Identity(n int) [][]float64 {
+	m := make([][]float64, n)
+	for i := range m {
+		m[i] = make([]float64, n)
+		m[i][i] = 1
+	}
+
+	return m
+}
+
+// Dot returns the inner product of two vectors of equal length.
+func Dot(x, y []float64) float64 {
+	sum := 0.0
+	for i := range x {
+		sum += x[i] * y[i]
+	}
+
+	return sum
+}
+
+// MatVec returns the matrix vector product m·x.
+func MatVec(m [][]float64, x []float64) []float64 {
+	y := make([]float64, len(m))
+	for i, row := range m {
+		y[i] = Dot(row, x)
+	}
+
+	return y
+}
+
+// ShermanMorrison updates the inverse `inv` of a symmetric matrix A in place,
+// so that it becomes the inverse of A + x·xᵀ.
+func ShermanMorrison(inv [][]float64, x []float64) {
+	u := MatVec(inv, x)
+	denominator := 1 + Dot(x, u)
+	for i := range inv {
+		for j := range inv[i] {
+			inv[i][j] -= u[i] * u[j] / denominator
+		}
+	}
+}
+
+// Inverse returns the inverse of the square matrix m, computed by Gauss-Jordan
+// elimination with partial pivoting. m is not modified. Returns false if m is
+// singular.
+func Inverse(m [][]float64) ([][]float64, bool) {
+	n := len(m)
+	identity := Identity(n)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = append(append([]float64{}, m[i]...), identity[i]...)
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if a[pivot][col] == 0 {
+			return nil, false
+		}
+
+		a[col], a[pivot] = a[pivot], a[col]
+		scale := a[col][col]
+		for j := range a[col] {
+			a[col][j] /= scale
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+
+			factor := a[row][col]
+			for j := range a[row] {
+				a[row][j] -= factor * a[col][j]
+			}
+		}
+	}
+
+	inverse := make([][]float64, n)
+	for i := range inverse {
+		inverse[i] = a[i][n:]
+	}
+
+	return inverse, true
+}
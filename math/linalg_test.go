@@ -0,0 +1,32 @@
+package math
+
+import (
+	"math"
+	"testing"
+)
+
+func TestInverse(t *testing.T) {
+	m := [][]float64{{0, 2, 1}, {1, 1, 0}, {2, 0, 3}}
+	inverse, ok := Inverse(m)
+	if !ok {
+		t.Fatalf("expected %v to be invertible", m)
+	}
+
+	for i := range m {
+		for j := range m {
+			expected := 0.0
+			if i == j {
+				expected = 1
+			}
+
+			column := []float64{inverse[0][j], inverse[1][j], inverse[2][j]}
+			if got := Dot(m[i], column); math.Abs(got-expected) > 1e-12 {
+				t.Fatalf("m·m⁻¹ should be the identity, is %f at (%d, %d)", got, i, j)
+			}
+		}
+	}
+
+	if _, ok := Inverse([][]float64{{1, 2}, {2, 4}}); ok {
+		t.Fatalf("expected singular matrix not to be invertible")
+	}
+}
@@ -14,7 +14,12 @@ type BetaRand struct {
 // NewBetaRand returns a new BetaRand that uses random values from rand
 // to generate beta random values.
 func NewBetaRand(seed int64) *BetaRand {
-	return &BetaRand{rand.New(rand.NewSource(seed))}
+	return NewBetaRandSource(rand.NewSource(seed))
+}
+
+// NewBetaRandSource returns a new BetaRand that draws from `src`.
+func NewBetaRandSource(src rand.Source) *BetaRand {
+	return &BetaRand{rand.New(src)}
 }
 
 // NextBeta returns beta distributed random variables: x ~ Beta(α, β)
@@ -44,7 +49,12 @@ func (r *BetaRand) NextBeta(α, β float64) float64 {
 
 // NormRand returns normally distributed random variables: x ~ N(x|μ,σ)
 func NormRand(μ, σ float64) func() float64 {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return NormRandSource(μ, σ, rand.NewSource(time.Now().UnixNano()))
+}
+
+// NormRandSource is NormRand drawing from `src`.
+func NormRandSource(μ, σ float64, src rand.Source) func() float64 {
+	r := rand.New(src)
 	return func() float64 {
 		return r.NormFloat64()*σ + μ
 	}
@@ -59,7 +69,12 @@ func DiracRand(c float64) func() float64 {
 
 // BernRand returns Bernoulli distributed random variables: x ~ Bern(x|μ)
 func BernRand(μ float64) func() float64 {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return BernRandSource(μ, rand.NewSource(time.Now().UnixNano()))
+}
+
+// BernRandSource is BernRand drawing from `src`.
+func BernRandSource(μ float64, src rand.Source) func() float64 {
+	r := rand.New(src)
 	return func() float64 {
 		res := 0.0
 		if r.Float64() <= μ {
@@ -68,3 +83,22 @@ func BernRand(μ float64) func() float64 {
 		return res
 	}
 }
+
+// PoissRand returns Poisson distributed random variables: x ~ Poiss(x|λ)
+func PoissRand(λ float64) func() float64 {
+	return PoissRandSource(λ, rand.NewSource(time.Now().UnixNano()))
+}
+
+// PoissRandSource is PoissRand drawing from `src`.
+func PoissRandSource(λ float64, src rand.Source) func() float64 {
+	r := rand.New(src)
+	l := math.Exp(-λ)
+	return func() float64 {
+		k, p := 0.0, r.Float64()
+		for p > l {
+			k++
+			p *= r.Float64()
+		}
+		return k
+	}
+}
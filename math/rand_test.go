@@ -43,3 +43,50 @@ func TestBetaSeed(t *testing.T) {
 		t.Fatalf("beta random variable should be %f, but is %f", expected, got)
 	}
 }
+
+func TestGammaRand(t *testing.T) {
+	var seed int64 = 123
+	gammaRnd := NewGammaRand(seed)
+	numSamples := 1000000
+
+	for _, params := range [][]float64{{0.5, 2}, {3, 0.5}} {
+		α, β := params[0], params[1]
+		expectation := α / β
+		variance := α / (β * β)
+
+		mean, mean2 := 0.0, 0.0
+		for i := 0; i < numSamples; i++ {
+			x := gammaRnd.NextGamma(α, β)
+			mean += x
+			mean2 += x * x
+		}
+		mean /= float64(numSamples)
+		mean2 /= float64(numSamples)
+
+		// compare mean with expected value
+		if math.Abs(mean-expectation) > 0.01*expectation {
+			t.Fatalf("mean converge to %f. is %f", expectation, mean)
+		}
+
+		// compare sample variance with variance
+		if got := mean2 - mean*mean; math.Abs(got-variance) > 0.02*variance {
+			t.Fatalf("variance converge to %f. is %f", variance, got)
+		}
+	}
+}
+
+func TestPoissRand(t *testing.T) {
+	λ := 4.0
+	poissRnd := PoissRand(λ)
+	numSamples := 100000
+
+	mean := 0.0
+	for i := 0; i < numSamples; i++ {
+		mean += poissRnd()
+	}
+	mean /= float64(numSamples)
+
+	if math.Abs(mean-λ) > 0.05 {
+		t.Fatalf("mean converge to %f. is %f", λ, mean)
+	}
+}
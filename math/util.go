@@ -15,3 +15,34 @@ func Max(array []float64) (float64, []int) {
 	}
 	return max, imax
 }
+
+// KLBernoulli returns the Kullback-Leibler divergence KL(Bern(p) || Bern(q)).
+func KLBernoulli(p, q float64) float64 {
+	ε := 1e-15
+	p = math.Min(math.Max(p, ε), 1-ε)
+	q = math.Min(math.Max(q, ε), 1-ε)
+	return p*math.Log(p/q) + (1-p)*math.Log((1-p)/(1-q))
+}
+
+// Bisect returns x ∈ [lo, hi] where the increasing function f crosses 0, to
+// within `tolerance`. Returns lo if f(lo) > 0 and hi if f(hi) <= 0.
+func Bisect(f func(float64) float64, lo, hi, tolerance float64) float64 {
+	if f(lo) > 0 {
+		return lo
+	}
+
+	if f(hi) <= 0 {
+		return hi
+	}
+
+	for hi-lo > tolerance {
+		mid := (lo + hi) / 2
+		if f(mid) > 0 {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return (lo + hi) / 2
+}
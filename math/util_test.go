@@ -0,0 +1,28 @@
+package math
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKLBernoulli(t *testing.T) {
+	if got := KLBernoulli(0.3, 0.3); math.Abs(got) > 1e-12 {
+		t.Fatalf("divergence of equal distributions should be 0, is %f", got)
+	}
+
+	expected := 0.5*math.Log(0.5/0.25) + 0.5*math.Log(0.5/0.75)
+	if got := KLBernoulli(0.5, 0.25); math.Abs(got-expected) > 1e-12 {
+		t.Fatalf("divergence should be %f, is %f", expected, got)
+	}
+}
+
+func TestBisect(t *testing.T) {
+	f := func(x float64) float64 { return x*x - 2 }
+	if got := Bisect(f, 0, 2, 1e-9); math.Abs(got-math.Sqrt2) > 1e-8 {
+		t.Fatalf("root should be %f, is %f", math.Sqrt2, got)
+	}
+
+	if got := Bisect(f, 0, 1, 1e-9); got != 1 {
+		t.Fatalf("root outside interval should return upper bound, got %f", got)
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// newDiscountedCounters constructs counters which discount past rewards by γ
+// with every update.
+func newDiscountedCounters(arms int, γ float64) discountedCounters {
+	return discountedCounters{
+		Counters: NewCounters(arms),
+		gamma:    γ,
+		weights:  make([]float64, arms),
+		sums:     make([]float64, arms),
+	}
+}
+
+// discountedCounters keep exponentially decayed statistics next to the all
+// time running averages, so that recent rewards dominate.
+type discountedCounters struct {
+	Counters
+	gamma   float64   // discount applied to all past rewards at each update
+	weights []float64 // discounted number of rewards per arm
+	sums    []float64 // discounted sum of rewards per arm
+}
+
+// Update discounts all arms and adds the reward to the 1 indexed arm.
+func (d *discountedCounters) Update(arm int, reward float64) {
+	d.Counters.Update(arm, reward)
+
+	d.Lock()
+	defer d.Unlock()
+
+	for i := range d.weights {
+		d.weights[i] *= d.gamma
+		d.sums[i] *= d.gamma
+	}
+
+	d.weights[arm-1]++
+	d.sums[arm-1] += reward
+}
+
+// Init takes the snapshot as undiscounted statistics.
+func (d *discountedCounters) Init(snapshot *Counters) error {
+	if err := d.Counters.Init(snapshot); err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for i := 0; i < d.arms; i++ {
+		d.weights[i] = float64(d.counts[i])
+		d.sums[i] = float64(d.counts[i]) * d.values[i]
+	}
+
+	return nil
+}
+
+// addArm appends an arm whose prior statistics are taken as undiscounted.
+func (d *discountedCounters) addArm(count int, value float64) {
+	d.Counters.addArm(count, value)
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.weights = append(d.weights, float64(count))
+	d.sums = append(d.sums, float64(count)*value)
+}
+
+// removeArm removes the 1 indexed arm.
+func (d *discountedCounters) removeArm(arm int) {
+	d.Counters.removeArm(arm)
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.weights = removeFloat(d.weights, arm-1)
+	d.sums = removeFloat(d.sums, arm-1)
+}
+
+// Reset the counters to initial state.
+func (d *discountedCounters) Reset() {
+	d.Counters.Reset()
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.weights = make([]float64, d.arms)
+	d.sums = make([]float64, d.arms)
+}
+
+// windowCounters keep statistics over the last `window` rewards next to the
+// all time running averages.
+type windowCounters struct {
+	Counters
+	window  int       // number of rewards to keep
+	history []int     // ring buffer of rewarded 0 indexed arms
+	rewards []float64 // ring buffer of rewards
+	next    int       // next position in the ring buffer
+	weights []float64 // number of rewards in window per arm
+	sums    []float64 // sum of rewards in window per arm
+}
+
+// Update adds the reward to the 1 indexed arm and evicts the oldest reward
+// once the window is full.
+func (w *windowCounters) Update(arm int, reward float64) {
+	w.Counters.Update(arm, reward)
+
+	w.Lock()
+	defer w.Unlock()
+
+	if old := w.history[w.next]; old >= 0 {
+		w.weights[old]--
+		w.sums[old] -= w.rewards[w.next]
+	}
+
+	w.history[w.next], w.rewards[w.next] = arm-1, reward
+	w.weights[arm-1]++
+	w.sums[arm-1] += reward
+	w.next = (w.next + 1) % w.window
+}
+
+// Init takes the snapshot as the contents of the window. Snapshot rewards
+// are never evicted, so snapshots should be windowed already.
+func (w *windowCounters) Init(snapshot *Counters) error {
+	if err := w.Counters.Init(snapshot); err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.reset()
+	for i := 0; i < w.arms; i++ {
+		w.weights[i] = float64(w.counts[i])
+		w.sums[i] = float64(w.counts[i]) * w.values[i]
+	}
+
+	return nil
+}
+
+// addArm appends an arm. Like snapshots, prior statistics are never evicted
+// from the window.
+func (w *windowCounters) addArm(count int, value float64) {
+	w.Counters.addArm(count, value)
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.weights = append(w.weights, float64(count))
+	w.sums = append(w.sums, float64(count)*value)
+}
+
+// removeArm removes the 1 indexed arm and its rewards from the window.
+func (w *windowCounters) removeArm(arm int) {
+	w.Counters.removeArm(arm)
+
+	w.Lock()
+	defer w.Unlock()
+
+	arm--
+	for i, old := range w.history {
+		switch {
+		case old == arm:
+			w.history[i] = -1
+		case old > arm:
+			w.history[i]--
+		}
+	}
+
+	w.weights = removeFloat(w.weights, arm)
+	w.sums = removeFloat(w.sums, arm)
+}
+
+// Reset the counters to initial state.
+func (w *windowCounters) Reset() {
+	w.Counters.Reset()
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.reset()
+}
+
+// reset empties the window.
+func (w *windowCounters) reset() {
+	w.history = make([]int, w.window)
+	for i := range w.history {
+		w.history[i] = -1
+	}
+
+	w.rewards = make([]float64, w.window)
+	w.next = 0
+	w.weights = make([]float64, w.arms)
+	w.sums = make([]float64, w.arms)
+}
+
+// total returns the number of rewards in the window. Callers hold the lock.
+func (w *windowCounters) total() float64 {
+	total := 0.0
+	for _, weight := range w.weights {
+		total += weight
+	}
+
+	return total
+}
+
+// NewDiscountedUCB returns a discounted UCB strategy (Garivier & Moulines,
+// 2008). Past rewards are discounted by γ at every update.
+func NewDiscountedUCB(arms int, γ float64) (Strategy, error) {
+	if !(γ > 0 && γ <= 1) {
+		return &discountedUCB{}, fmt.Errorf("γ not in (0, 1]")
+	}
+
+	return &discountedUCB{
+		discountedCounters: newDiscountedCounters(arms, γ),
+	}, nil
+}
+
+// discountedUCB is UCB1 on discounted statistics.
+type discountedUCB struct {
+	discountedCounters
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (d *discountedUCB) SelectArm() int {
+	imax := d.candidates()
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[d.rand.Intn(len(imax))]
+
+	d.pull(arm + 1)
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (d *discountedUCB) Probabilities() []float64 {
+	return uniformOver(d.candidates(), d.arms)
+}
+
+// candidates returns the 0 indexed arms with the highest discounted bound.
+func (d *discountedUCB) candidates() []int {
+	d.RLock()
+	defer d.RUnlock()
+
+	total := 0.0
+	for _, weight := range d.weights {
+		total += weight
+	}
+
+	return weightedUCBCandidates(d.weights, d.sums, total)
+}
+
+// String returns information on this strategy
+func (d *discountedUCB) String() string {
+	return fmt.Sprintf("DiscountedUCB(gamma=%.2f)", d.gamma)
+}
+
+// NewSlidingWindowUCB returns a sliding window UCB strategy (Garivier &
+// Moulines, 2008). Only the last τ rewards are considered.
+func NewSlidingWindowUCB(arms int, τ int) (Strategy, error) {
+	if τ < 1 {
+		return &slidingWindowUCB{}, fmt.Errorf("τ not in [1, ∞)")
+	}
+
+	s := &slidingWindowUCB{
+		windowCounters: windowCounters{
+			Counters: NewCounters(arms),
+			window:   τ,
+		},
+	}
+
+	s.Reset()
+	return s, nil
+}
+
+// slidingWindowUCB is UCB1 on windowed statistics.
+type slidingWindowUCB struct {
+	windowCounters
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (s *slidingWindowUCB) SelectArm() int {
+	imax := s.candidates()
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[s.rand.Intn(len(imax))]
+
+	s.pull(arm + 1)
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (s *slidingWindowUCB) Probabilities() []float64 {
+	return uniformOver(s.candidates(), s.arms)
+}
+
+// candidates returns the 0 indexed arms with the highest windowed bound.
+func (s *slidingWindowUCB) candidates() []int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return weightedUCBCandidates(s.weights, s.sums, s.total())
+}
+
+// String returns information on this strategy
+func (s *slidingWindowUCB) String() string {
+	return fmt.Sprintf("SlidingWindowUCB(tau=%d)", s.window)
+}
+
+// weightedUCBCandidates returns the 0 indexed arms with the highest UCB1
+// bound given weighted counts and reward sums. Arms without weight are tried
+// first.
+func weightedUCBCandidates(weights, sums []float64, total float64) []int {
+	ucbValues := make([]float64, len(weights))
+	for i, weight := range weights {
+		if weight <= 0 {
+			ucbValues[i] = math.Inf(1)
+			continue
+		}
+
+		bonus := math.Sqrt((2 * math.Log(math.Max(total, 1))) / weight)
+		ucbValues[i] = sums[i]/weight + bonus
+	}
+
+	_, imax := bmath.Max(ucbValues)
+	return imax
+}
+
+// NewDiscountedThompson returns a discounted thompson sampling strategy for
+// Bernoulli rewards. Past rewards are discounted by γ at every update, α is
+// the strength of the prior.
+func NewDiscountedThompson(arms int, γ, α float64) (Strategy, error) {
+	if !(γ > 0 && γ <= 1) {
+		return &discountedThompson{}, fmt.Errorf("γ not in (0, 1]")
+	}
+
+	if !(α > 0.0) {
+		return &discountedThompson{}, fmt.Errorf("α not in (0, ∞]")
+	}
+
+	return &discountedThompson{
+		discountedCounters: newDiscountedCounters(arms, γ),
+		alpha:              α,
+		betaRand:           bmath.NewBetaRand(time.Now().UnixNano()),
+	}, nil
+}
+
+// discountedThompson is thompson sampling on discounted statistics.
+type discountedThompson struct {
+	discountedCounters
+	alpha     float64 // strength of prior distribution
+	betaRand  *bmath.BetaRand
+	estimates estimates // cached selection probabilities
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (d *discountedThompson) SelectArm() int {
+	var thetas = make([]float64, d.arms)
+	for i := 0; i < d.arms; i++ {
+		thetas[i] = d.sample(i)
+	}
+
+	_, imax := bmath.Max(thetas)
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[d.rand.Intn(len(imax))]
+
+	d.pull(arm + 1)
+	return arm + 1
+}
+
+// Seed replaces the random source of the strategy and its sampler.
+func (d *discountedThompson) Seed(src rand.Source) {
+	d.Counters.Seed(src)
+	d.betaRand = bmath.NewBetaRandSource(src)
+}
+
+// Probabilities returns Monte Carlo estimates of the probability of selecting
+// each arm. Estimates are cached until the next reward or snapshot.
+func (d *discountedThompson) Probabilities() []float64 {
+	return d.estimates.get(&d.Counters, func() []float64 {
+		return estimateProbabilities(d.arms, d.sample)
+	})
+}
+
+// rewardRange returns [0, 1], rewards are Bernoulli.
+func (d *discountedThompson) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// sample draws the mean reward of the 0 indexed arm from its posterior.
+func (d *discountedThompson) sample(arm int) float64 {
+	d.RLock()
+	defer d.RUnlock()
+
+	si := d.sums[arm]
+	fi := d.weights[arm] - si
+	return d.betaRand.NextBeta(si+d.alpha, fi+d.alpha)
+}
+
+// String returns information on this strategy
+func (d *discountedThompson) String() string {
+	return fmt.Sprintf("DiscountedThompson(gamma=%.2f, alpha=%.2f)", d.gamma, d.alpha)
+}
@@ -0,0 +1,40 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+// override returns the variation forced for the request, either by the
+// allowlist of a variation or by one of the request's overrides. Overrides
+// are tags signed with a keyring, which have to be verified by the caller.
+// Overrides of other experiments are ignored.
+func (e *Experiment) override(r Request) (Variation, bool) {
+	if v, ok := e.allowlisted(r.UID); ok {
+		return v, true
+	}
+
+	for _, tag := range r.Overrides {
+		if v, err := e.GetTaggedVariation(tag); err == nil {
+			return v, true
+		}
+	}
+
+	return Variation{}, false
+}
+
+// allowlisted returns the variation which has the uid on its allowlist.
+func (e *Experiment) allowlisted(uid string) (Variation, bool) {
+	if uid == "" {
+		return Variation{}, false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, v := range e.Variations {
+		if contains(v.Overrides, uid) {
+			return v, true
+		}
+	}
+
+	return Variation{}, false
+}
@@ -0,0 +1,56 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExperimentOverride(t *testing.T) {
+	config := stringOpener(`[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"preferred": 1,
+		"state": "paused",
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2, "overrides": ["qa-1"]}
+		]
+	}]`)
+
+	e, err := NewExperiment(config, "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	for _, r := range []Request{
+		{UID: "qa-1"},
+		{UID: "11", Overrides: []string{"other:1", "shape:2"}},
+	} {
+		s, tag, err := e.SelectTimestampedRequest("", r, time.Hour)
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if !s.Override || s.Ordinal != 2 || tag != "" {
+			t.Fatalf("expected untagged override of square, got %v with tag '%s'", s, tag)
+		}
+
+		if line := SelectionLine(e, s); !strings.Contains(line, "BanditOverride") {
+			t.Fatalf("expected override line, got %s", line)
+		}
+	}
+
+	s, err := e.SelectRequest(Request{UID: "11"})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if s.Override || s.Ordinal != 1 {
+		t.Fatalf("expected preferred variation without override, got %v", s)
+	}
+}
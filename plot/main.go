@@ -90,6 +90,29 @@ func main() {
 		strategys: ucb1s,
 	})
 
+	// ucb variants
+	klUCB, err := bandit.NewKLUCB(len(μs), 0)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	ucbV, err := bandit.NewUCBV(len(μs), 1.2)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	ucbs := strategys{
+		bandit.NewUCB1(len(μs)),
+		klUCB,
+		bandit.NewUCB1Tuned(len(μs)),
+		ucbV,
+	}
+
+	groups = append(groups, group{
+		name:      "UCB Variants",
+		strategys: ucbs,
+	})
+
 	// thompson sampling
 	thompsons := strategys{}
 	for _, α := range []float64{1, 2, 10, 20, 100} {
@@ -138,22 +161,68 @@ func main() {
 
 	mixed = append(mixed, thompson)
 
+	// ucb variants into mixed
+	mixed = append(mixed, klUCB, bandit.NewUCB1Tuned(len(μs)), ucbV)
+
 	groups = append(groups, group{
 		name:      "Comparative",
 		strategys: mixed,
 	})
 
+	// non-stationary. arms are reversed half way through the horizon, so
+	// accuracy is measured against the best arms after the change.
+	reversed, bestReversed := []sim.Arm{}, []int{}
+	for i := range arms {
+		reversed = append(reversed, arms[len(arms)-1-i])
+	}
+
+	for _, arm := range bestArms {
+		bestReversed = append(bestReversed, len(arms)+1-arm)
+	}
+
+	stationary, err := bandit.NewThompson(len(μs), 1)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	dUCB, err := bandit.NewDiscountedUCB(len(μs), 0.95)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	swUCB, err := bandit.NewSlidingWindowUCB(len(μs), 50)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	dThompson, err := bandit.NewDiscountedThompson(len(μs), 0.95, 1)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	groups = append(groups, group{
+		name:      "Non-Stationary",
+		strategys: strategys{stationary, dUCB, swUCB, dThompson},
+		scenario:  sim.ChangePoint(*mcHorizon/2, arms, reversed),
+		bestArms:  bestReversed,
+	})
+
 	// draw groups
 	for _, group := range groups {
-		s, err := simulate(group.strategys, arms, *mcSims, *mcHorizon)
+		scenario, best := sim.Stationary(arms), bestArms
+		if group.scenario != nil {
+			scenario, best = group.scenario, group.bestArms
+		}
+
+		s, err := simulate(group.strategys, scenario, *mcSims, *mcHorizon)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		graph := summarize(s, sim.Accuracy(bestArms))
+		graph := summarize(s, sim.Accuracy(best))
 		draw(graph, group.name+" Accuracy", "Time", "P(selecting best arm)")
 
-		s, err = simulate(group.strategys, arms, *mcSims, *mcHorizon)
+		s, err = simulate(group.strategys, scenario, *mcSims, *mcHorizon)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -161,7 +230,7 @@ func main() {
 		graph = summarize(s, sim.Performance)
 		draw(graph, group.name+" Performance", "Time", "Average Reward")
 
-		s, err = simulate(group.strategys, arms, *mcSims, *mcHorizon)
+		s, err = simulate(group.strategys, scenario, *mcSims, *mcHorizon)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -94,11 +94,11 @@ type simulations []sim.Simulation
 // arms
 type arms []sim.Arm
 
-// simulate runs a Monte Carlo simulation with given arms and strategys
-func simulate(bs strategys, arms arms, sims, horizon int) (simulations, error) {
+// simulate runs a Monte Carlo simulation with given scenario and strategys
+func simulate(bs strategys, scenario sim.Scenario, sims, horizon int) (simulations, error) {
 	ret := simulations{}
 	for _, b := range bs {
-		s, err := sim.MonteCarlo(sims, horizon, arms, b)
+		s, err := sim.MonteCarloScenario(sims, horizon, scenario, b)
 		if err != nil {
 			return simulations{}, fmt.Errorf(err.Error())
 		}
@@ -113,6 +113,8 @@ func simulate(bs strategys, arms arms, sims, horizon int) (simulations, error) {
 type group struct {
 	name      string
 	strategys strategys
+	scenario  sim.Scenario // stationary arms if nil
+	bestArms  []int        // best arms of the scenario, if set
 }
 
 // summarize summarizes simulations and coverts the to graph
@@ -0,0 +1,140 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	bmath "github.com/purzelrakete/bandit/math"
+	"sync"
+)
+
+// Probabilistic strategies report the probability with which each arm would
+// be selected by the next call to SelectArm. These are the propensities
+// needed for off-policy evaluation.
+type Probabilistic interface {
+	Probabilities() []float64
+}
+
+// probabilityDraws is the number of posterior samples used to estimate the
+// selection probabilities of sampling strategies.
+const probabilityDraws = 1000
+
+// uniformOver returns probabilities which are uniform over the given 0
+// indexed candidate arms and 0 for all other arms.
+func uniformOver(candidates []int, arms int) []float64 {
+	ps := make([]float64, arms)
+	for _, i := range candidates {
+		ps[i] = 1 / float64(len(candidates))
+	}
+
+	return ps
+}
+
+// estimateProbabilities estimates the probability that each 0 indexed arm
+// draws the highest sample. Ties are split evenly.
+func estimateProbabilities(arms int, sample func(arm int) float64) []float64 {
+	ps := make([]float64, arms)
+	thetas := make([]float64, arms)
+	for draw := 0; draw < probabilityDraws; draw++ {
+		for i := 0; i < arms; i++ {
+			thetas[i] = sample(i)
+		}
+
+		_, imax := bmath.Max(thetas)
+		for _, i := range imax {
+			ps[i] += 1 / float64(len(imax)*probabilityDraws)
+		}
+	}
+
+	return ps
+}
+
+// estimateInclusions estimates the probability that each 0 indexed arm draws
+// one of the k highest samples. Half a pseudo draw is added to each arm and
+// to its complement, so that no arm has probability 0.
+func estimateInclusions(arms, k int, sample func(arm int) float64) []float64 {
+	ps := make([]float64, arms)
+	thetas := make([]float64, arms)
+	order := make([]int, arms)
+	for draw := 0; draw < probabilityDraws; draw++ {
+		for i := 0; i < arms; i++ {
+			thetas[i] = sample(i)
+			order[i] = i
+		}
+
+		for _, arm := range topK(thetas, k, order) {
+			ps[arm-1]++
+		}
+	}
+
+	for i, p := range ps {
+		ps[i] = (p + 0.5) / (probabilityDraws + 1)
+	}
+
+	return ps
+}
+
+// smoothed returns Monte Carlo estimates with half a pseudo draw added to each
+// arm, so that no arm has probability 0. Selected arms must have a positive
+// propensity, or inverse propensity weighting breaks.
+func smoothed(ps []float64) []float64 {
+	arms := float64(len(ps))
+	for i, p := range ps {
+		ps[i] = (p*probabilityDraws + 0.5) / (probabilityDraws + 0.5*arms)
+	}
+
+	return ps
+}
+
+// estimates caches Monte Carlo estimates of selection probabilities, which
+// are too expensive to draw on every selection. Estimates are redrawn once
+// rewards or snapshots changed the counters; pulls do not redraw them.
+type estimates struct {
+	mu      sync.Mutex
+	ps      []float64
+	version int // version of the counters the estimates were drawn from
+}
+
+// get returns a copy of the cached estimates of counters c, drawing new ones
+// with `estimate` if c changed.
+func (e *estimates) get(c *Counters, estimate func() []float64) []float64 {
+	version, arms := c.state()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ps == nil || e.version != version || len(e.ps) != arms {
+		e.ps, e.version = smoothed(estimate()), version
+	}
+
+	return append([]float64{}, e.ps...)
+}
+
+// inclusions caches Monte Carlo estimates of slate probabilities by slate
+// size, like estimates.
+type inclusions struct {
+	mu      sync.Mutex
+	ps      map[int][]float64
+	version int // version of the counters the estimates were drawn from
+}
+
+// get returns a copy of the cached estimates for slates of k arms of counters
+// c, drawing new ones with `estimate` if c changed.
+func (in *inclusions) get(c *Counters, k int, estimate func() []float64) []float64 {
+	version, arms := c.state()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.ps == nil || in.version != version {
+		in.ps, in.version = make(map[int][]float64), version
+	}
+
+	ps, ok := in.ps[k]
+	if !ok || len(ps) != arms {
+		ps = estimate()
+		in.ps[k] = ps
+	}
+
+	return append([]float64{}, ps...)
+}
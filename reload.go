@@ -0,0 +1,228 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Source provides the current experiments. Experiments are a static source,
+// while reloaders replace their experiments whenever the configuration
+// changes.
+type Source interface {
+	Current() *Experiments
+}
+
+// Current returns the experiments themselves.
+func (es *Experiments) Current() *Experiments {
+	return es
+}
+
+// NewReloader loads experiments from the opener, and reloads them every
+// `poll` duration. A poll of 0 disables polling; call Reload instead, e.g.
+// on SIGHUP.
+func NewReloader(o Opener, poll time.Duration) (*Reloader, error) {
+	jsonString, err := readExperiments(o)
+	if err != nil {
+		return &Reloader{}, err
+	}
+
+	es, err := parseExperiments(jsonString)
+	if err != nil {
+		return &Reloader{}, err
+	}
+
+	r := &Reloader{
+		opener:      o,
+		experiments: es,
+		last:        jsonString,
+	}
+
+	if poll > 0 {
+		go func() {
+			t := time.NewTicker(poll)
+			for _ = range t.C {
+				if err := r.Reload(); err != nil {
+					log.Printf("Error: could not reload experiments: %s", err.Error())
+				}
+			}
+		}()
+	}
+
+	return r, nil
+}
+
+// Reloader holds experiments which are swapped atomically on reload.
+type Reloader struct {
+	opener      Opener
+	mu          sync.RWMutex // guards experiments
+	reloading   sync.Mutex   // serializes reloads
+	experiments *Experiments
+	last        []byte // configuration of the current experiments
+}
+
+// Current returns the current experiments.
+func (r *Reloader) Current() *Experiments {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.experiments
+}
+
+// Reload reads the configuration and swaps in the new experiments if it
+// changed. Experiments with unchanged variations and strategy configuration
+// keep their strategy and its state. The current experiments stay in place
+// if the new configuration is invalid, or if it moves an experiment into a
+// state it cannot reach, e.g. from concluded back to running.
+func (r *Reloader) Reload() error {
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	jsonString, err := readExperiments(r.opener)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(jsonString, r.last) {
+		return nil
+	}
+
+	es, err := parseExperiments(jsonString)
+	if err != nil {
+		return err
+	}
+
+	previous := r.Current()
+	if err := es.checkTransitions(previous, time.Now()); err != nil {
+		es.stop()
+		return err
+	}
+
+	for name, e := range *es {
+		if p, ok := (*previous)[name]; ok {
+			e.carryOver(p)
+		}
+	}
+
+	r.mu.Lock()
+	r.experiments, r.last = es, jsonString
+	r.mu.Unlock()
+
+	// stop polling snapshots of strategies which were not carried over
+	kept := make(map[Strategy]bool)
+	for _, e := range *es {
+		for _, x := range e.experiments() {
+			kept[x.Strategy] = true
+		}
+	}
+
+	for _, e := range *previous {
+		for _, x := range e.experiments() {
+			if !kept[x.Strategy] {
+				stopStrategy(x.Strategy)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkTransitions returns an error if an experiment would move into a state
+// it cannot reach from its previous state, e.g. from concluded to running.
+func (es *Experiments) checkTransitions(previous *Experiments, now time.Time) error {
+	for name, e := range *es {
+		p, ok := (*previous)[name]
+		if !ok {
+			continue
+		}
+
+		p.mu.RLock()
+		from := p.stateAt(now)
+		p.mu.RUnlock()
+
+		if to := e.stateAt(now); from != to && !validTransition(from, to) {
+			return fmt.Errorf("%s: cannot transition from %s to %s", name, from, to)
+		}
+	}
+
+	return nil
+}
+
+// stop stops polling snapshots of all experiments and their segments.
+func (es *Experiments) stop() {
+	for _, e := range *es {
+		for _, x := range e.experiments() {
+			stopStrategy(x.Strategy)
+		}
+	}
+}
+
+// carryOver moves the strategy and the declared winner of the previous
+// experiment and its segments into this freshly loaded experiment, if the
+// tags and ordinals of the variations and the strategy configuration did not
+// change.
+func (e *Experiment) carryOver(previous *Experiment) {
+	previous.mu.RLock()
+	defer previous.mu.RUnlock()
+
+	if !reflect.DeepEqual(e.config, previous.config) || !sameArms(e.Variations, previous.Variations) {
+		return
+	}
+
+	e.carryStrategy(previous)
+	for _, s := range e.Segments {
+		for _, p := range previous.Segments {
+			if s.Name == p.Name && reflect.DeepEqual(s.Experiment.config, p.Experiment.config) {
+				p.Experiment.mu.RLock()
+				s.Experiment.carryStrategy(p.Experiment)
+				p.Experiment.mu.RUnlock()
+			}
+		}
+	}
+}
+
+// sameArms returns true if the variations map onto the same arms, i.e. have
+// the same tags and ordinals in the same order. Other fields, e.g. urls and
+// payloads, may change without losing what the strategy learned.
+func sameArms(a, b Variations) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Tag != b[i].Tag || a[i].Ordinal != b[i].Ordinal {
+			return false
+		}
+	}
+
+	return true
+}
+
+// carryStrategy replaces the strategy and sticky assignments with the
+// previous ones.
+func (e *Experiment) carryStrategy(previous *Experiment) {
+	stopStrategy(e.Strategy)
+	e.Strategy = previous.Strategy
+	e.nextID = previous.nextID
+
+	e.assigned = previous.assigned
+
+	if previous.WinnerOrdinal > 0 {
+		e.WinnerOrdinal = previous.WinnerOrdinal
+		e.PreferredOrdinal = previous.PreferredOrdinal
+		e.Decision = previous.Decision
+	}
+}
+
+// stopStrategy stops delayed strategies from polling for snapshots.
+func stopStrategy(s Strategy) {
+	if d, ok := s.(*delayedStrategy); ok {
+		d.stop()
+	}
+}
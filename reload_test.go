@@ -0,0 +1,177 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	file, err := ioutil.TempFile("", "experiments")
+	if err != nil {
+		t.Fatalf("could not create experiments file: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+
+	config := `[
+		{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": %d,
+			"variations": [{"url": "circle", "ordinal": 1}, {"url": "square", "ordinal": 2}]
+		},
+		{
+			"experiment_name": "fonts",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"preferred": 1,
+			"variations": [{"url": "%s", "ordinal": 1}, {"url": "sans", "ordinal": 2}%s]
+		}
+	]`
+
+	write := func(preferred int, font, more string) {
+		data := []byte(fmt.Sprintf(config, preferred, font, more))
+		if err := ioutil.WriteFile(file.Name(), data, 0644); err != nil {
+			t.Fatalf("could not write experiments: %s", err.Error())
+		}
+	}
+
+	write(1, "serif", "")
+	r, err := NewReloader(NewFileOpener(file.Name()), 0)
+	if err != nil {
+		t.Fatalf("could not load experiments: %s", err.Error())
+	}
+
+	shape, fonts := (*r.Current())["shape"], (*r.Current())["fonts"]
+	shape.Strategy.Update(1, 1)
+
+	write(1, "mono", "")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("could not reload experiments: %s", err.Error())
+	}
+
+	if got := (*r.Current())["shape"]; got == shape || got.Strategy != shape.Strategy {
+		t.Fatalf("expected new shape experiment with previous strategy")
+	}
+
+	if got := (*r.Current())["fonts"]; got.Strategy != fonts.Strategy || got.Variations[0].URL != "mono" {
+		t.Fatalf("expected fonts experiment with a new url to keep its strategy")
+	}
+
+	write(1, "mono", `, {"url": "slab", "ordinal": 3}`)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("could not reload experiments: %s", err.Error())
+	}
+
+	if got := (*r.Current())["fonts"]; got.Strategy == fonts.Strategy {
+		t.Fatalf("expected fonts experiment with a new variation to get a new strategy")
+	}
+
+	current := r.Current()
+	write(3, "mono", "")
+	if err := r.Reload(); err == nil {
+		t.Fatalf("expected invalid experiments to be rejected")
+	}
+
+	if r.Current() != current {
+		t.Fatalf("expected previous experiments to stay in place")
+	}
+}
+
+func TestReloaderInvalidStopsStrategies(t *testing.T) {
+	snapshot, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatalf("could not create snapshot: %s", err.Error())
+	}
+
+	defer os.Remove(snapshot.Name())
+	snapshot.WriteString("2	0.1	0.5")
+	snapshot.Close()
+
+	// both experiments claim the whole layer
+	config := []byte(fmt.Sprintf(`[
+		{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"snapshot": "%s",
+			"snapshot-poll-seconds": 3600,
+			"layer": "checkout",
+			"preferred": 1,
+			"variations": [{"url": "circle", "ordinal": 1}, {"url": "square", "ordinal": 2}]
+		},
+		{
+			"experiment_name": "fonts",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"snapshot": "%s",
+			"snapshot-poll-seconds": 3600,
+			"layer": "checkout",
+			"preferred": 1,
+			"variations": [{"url": "serif", "ordinal": 1}, {"url": "sans", "ordinal": 2}]
+		}
+	]`, snapshot.Name(), snapshot.Name()))
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		if _, err := parseExperiments(config); err == nil {
+			t.Fatalf("expected overlapping experiments to be rejected")
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("leaked %d goroutines", runtime.NumGoroutine()-before)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloaderTransitions(t *testing.T) {
+	file, err := ioutil.TempFile("", "experiments")
+	if err != nil {
+		t.Fatalf("could not create experiments file: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+
+	write := func(state State) {
+		data := []byte(fmt.Sprintf(`[{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"state": "%s",
+			"preferred": 1,
+			"variations": [{"url": "circle", "ordinal": 1}, {"url": "square", "ordinal": 2}]
+		}]`, state))
+
+		if err := ioutil.WriteFile(file.Name(), data, 0644); err != nil {
+			t.Fatalf("could not write experiments: %s", err.Error())
+		}
+	}
+
+	write(Concluded)
+	r, err := NewReloader(NewFileOpener(file.Name()), 0)
+	if err != nil {
+		t.Fatalf("could not load experiments: %s", err.Error())
+	}
+
+	write(Running)
+	if err := r.Reload(); err == nil {
+		t.Fatalf("expected concluded experiment not to be restarted")
+	}
+
+	if got := (*r.Current())["shape"].Status(); got != Concluded {
+		t.Fatalf("expected experiment to stay concluded, got %s", got)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Rules restrict an experiment or a segment to requests with the given
+// attributes, e.g. {"country": ["de", "at"], "platform": ["ios"]}. A request
+// matches if each of its attributes named in the rules has one of the listed
+// values. Empty rules match all requests.
+type Rules map[string][]string
+
+// Match returns true if the attributes satisfy all rules.
+func (r Rules) Match(attributes map[string]string) bool {
+	for attribute, values := range r {
+		value, ok := attributes[attribute]
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contains returns true if value is one of values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Segment is a part of the audience of an experiment with its own strategy
+// and snapshot. The segment experiment is named <experiment>@<segment>, and
+// shares the variations of the experiment under its own tags.
+type Segment struct {
+	Name       string
+	Rules      Rules
+	Experiment *Experiment
+}
+
+// segmentConfig configures a segment in experiments.json. Segments without a
+// strategy use the strategy of the experiment.
+type segmentConfig struct {
+	strategyConfig
+	Name  string `json:"name"`
+	Rules Rules  `json:"rules"`
+}
+
+// Request describes the request a variation is selected for.
+type Request struct {
+	UID        string            // sticky assignment if not blank
+	Features   []float64         // features for contextual strategies
+	Attributes map[string]string // attributes matched against audience and segments
+	Overrides  []string          // tags of overrides, verified with Keyring.Verify
+}
+
+// SelectRequest selects a variation for the request. Requests outside the
+// audience of the experiment, or with uids outside the experiment's buckets,
+// get the preferred variation, which is marked as excluded and should not be
+// logged. Users outside the allocation get the preferred variation as well,
+// and are logged as not enrolled. Other requests are served by the first
+// segment they match, or by the experiment itself. Overrides take precedence
+// over all of the above.
+func (e *Experiment) SelectRequest(r Request) (Selection, error) {
+	if v, ok := e.override(r); ok {
+		return Selection{Variation: v, Propensity: 1, Override: true}, nil
+	}
+
+	e.mu.RLock()
+	excluded := !e.Audience.Match(r.Attributes) || !e.claims(r.UID)
+	enrolled := e.enrolled(r.UID, time.Now())
+	preferred := e.variation(e.PreferredOrdinal)
+	e.mu.RUnlock()
+
+	if excluded || !enrolled {
+		return Selection{
+			Variation:   preferred,
+			Propensity:  1,
+			Excluded:    excluded,
+			NotEnrolled: !enrolled,
+		}, nil
+	}
+
+	target := e.segmentFor(r.Attributes)
+	if r.UID != "" {
+		return target.SelectSticky(r.UID, r.Features)
+	}
+
+	return target.selection(r.Features)
+}
+
+// SelectSlate selects k distinct variations for the request, in order of
+// position. Requests are gated like in SelectRequest: requests which get a
+// fixed variation get it on top of the slate, followed by the next
+// variations in order of ordinal, with the same marks. Slates are not sticky.
+func (e *Experiment) SelectSlate(r Request, k int) ([]Selection, error) {
+	if v, ok := e.override(r); ok {
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+
+		return e.fill(Selection{Variation: v, Propensity: 1, Override: true}, k)
+	}
+
+	e.mu.RLock()
+	excluded := !e.Audience.Match(r.Attributes) || !e.claims(r.UID)
+	enrolled := e.enrolled(r.UID, time.Now())
+	if excluded || !enrolled {
+		defer e.mu.RUnlock()
+
+		return e.fill(Selection{
+			Variation:   e.variation(e.PreferredOrdinal),
+			Propensity:  1,
+			Excluded:    excluded,
+			NotEnrolled: !enrolled,
+		}, k)
+	}
+
+	e.mu.RUnlock()
+	return e.segmentFor(r.Attributes).slate(k)
+}
+
+// SelectTimestampedRequest is SelectTimestampedContext for requests. Requests
+// with a uid are not pinned by timestamped tags, and neither are requests to
+// experiments which are not running. Selections which are not counted get a
+// blank timestamped tag, so that they cannot be rewarded.
+func (e *Experiment) SelectTimestampedRequest(
+	timestampedTag string,
+	r Request,
+	ttl time.Duration) (Selection, string, error) {
+	now := time.Now().Unix()
+
+	pinnable := r.UID == "" && len(r.Overrides) == 0 && e.claims(r.UID) &&
+		e.Audience.Match(r.Attributes) && e.Status() == Running
+	if timestampedTag == "" || !pinnable {
+		selected, err := e.SelectRequest(r)
+		if err != nil {
+			return Selection{}, "", err
+		}
+
+		if !selected.Counted() {
+			return selected, "", nil
+		}
+
+		return selected, selected.TimestampedTag(now), nil
+	}
+
+	tag, ts, err := TimestampedTagToTag(timestampedTag)
+	if err != nil {
+		return Selection{}, "", fmt.Errorf("bad timestamped tag: %s", err.Error())
+	}
+
+	// return the given timestamped tag
+	if ttl > time.Since(time.Unix(ts, 0)) {
+		v, err := e.GetTaggedVariation(tag)
+
+		// could not get tagged variation. this can occurr when switching between
+		// experiments. users still pinned to the previous experiment will see
+		// failures because the old experiment name is unknown.
+		if err != nil {
+			log.Printf("repinned after error: %s", err.Error())
+			return e.SelectTimestampedRequest("", r, ttl)
+		}
+
+		// rewards keep the propensity of the original selection
+		propensity, _ := TimestampedTagPropensity(timestampedTag)
+		return Selection{Variation: v, Propensity: 1}, makeTimestampedTag(v, ts, propensity), err
+	}
+
+	return e.SelectTimestampedRequest("", r, ttl)
+}
+
+// Update rewards the tagged variation. The reward goes to the strategy of
+// the segment the variation was selected in. Rewards outside the range
+// assumed by the strategy are rejected, e.g. rewards outside [0, 1] for
+// Bernoulli thompson sampling.
+func (e *Experiment) Update(v Variation, reward float64) error {
+	return e.UpdateWithPropensity(v, nil, 0, reward)
+}
+
+// UpdateWithContext is Update for variations selected with features.
+// Contextual strategies learn the reward given the features, which must be
+// the features of the selection. Other strategies ignore the features.
+func (e *Experiment) UpdateWithContext(v Variation, features []float64, reward float64) error {
+	return e.UpdateWithPropensity(v, features, 0, reward)
+}
+
+// UpdateWithPropensity is UpdateWithContext for rewards given with the
+// propensity logged when the variation was selected. Strategies which weight
+// rewards by the inverse propensity, e.g. exp3, use it instead of their
+// current probabilities. Other strategies ignore it. A propensity of 0 is
+// unknown.
+func (e *Experiment) UpdateWithPropensity(v Variation, features []float64, propensity, reward float64) error {
+	if !(propensity >= 0 && propensity <= 1) {
+		return fmt.Errorf("propensity %f not in [0, 1]", propensity)
+	}
+
+	for _, x := range e.experiments() {
+		if found, err := x.update(v.Tag, features, propensity, reward); found {
+			return err
+		}
+	}
+
+	return fmt.Errorf("tag '%s' is not in experiment %s", v.Tag, e.Name)
+}
+
+// update rewards the tagged variation of this experiment only. Returns false
+// if the tag is not in the experiment.
+func (e *Experiment) update(tag string, features []float64, propensity, reward float64) (bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	variation, err := e.taggedVariation(tag)
+	if err != nil {
+		return false, nil
+	}
+
+	if err := checkReward(e.Strategy, reward); err != nil {
+		return true, fmt.Errorf("%s: %s", e.Name, err.Error())
+	}
+
+	if s, ok := e.Strategy.(propensityUpdater); ok && propensity > 0 {
+		s.updateWithPropensity(variation.Ordinal, reward, propensity)
+		return true, nil
+	}
+
+	if features == nil {
+		e.Strategy.Update(variation.Ordinal, reward)
+		return true, nil
+	}
+
+	s, err := e.contextual(features)
+	if err != nil {
+		return true, err
+	}
+
+	if s == nil {
+		e.Strategy.Update(variation.Ordinal, reward)
+		return true, nil
+	}
+
+	s.UpdateWithContext(variation.Ordinal, features, reward)
+	return true, nil
+}
+
+// segmentFor returns the experiment of the first segment matching the
+// attributes, or the experiment itself.
+func (e *Experiment) segmentFor(attributes map[string]string) *Experiment {
+	for _, s := range e.Segments {
+		if s.Rules.Match(attributes) {
+			return s.Experiment
+		}
+	}
+
+	return e
+}
+
+// experiments returns the experiment followed by the experiments of its
+// segments.
+func (e *Experiment) experiments() []*Experiment {
+	experiments := []*Experiment{e}
+	for _, s := range e.Segments {
+		experiments = append(experiments, s.Experiment)
+	}
+
+	return experiments
+}
+
+// segment returns the experiment of segment `name`, sharing the variations of
+// this experiment under the segment's tags.
+func (e *Experiment) segment(name string, config strategyConfig, strategy Strategy) *Experiment {
+	s := &Experiment{
+		Name:             name,
+		Strategy:         strategy,
+		config:           config,
+		PreferredOrdinal: e.PreferredOrdinal,
+		Prior:            e.Prior,
+		Salt:             e.Salt,
+		State:            e.State,
+		Start:            e.Start,
+		End:              e.End,
+		Factors:          e.Factors,
+		Graduation:       e.Graduation,
+		nextID:           e.nextID,
+		assigned:         newAssignments(maxAssignments),
+	}
+
+	for _, v := range e.Variations {
+		v.Tag = e.segmentTag(s, v.Tag)
+		s.Variations = append(s.Variations, v)
+	}
+
+	s.tagged()
+	return s
+}
+
+// segmentTag maps the tag of a variation of this experiment onto the tag of
+// the same variation in segment experiment s.
+func (e *Experiment) segmentTag(s *Experiment, tag string) string {
+	return s.Name + strings.TrimPrefix(tag, e.Name)
+}
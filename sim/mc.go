@@ -15,8 +15,37 @@ type Strategy interface {
 // Arm simulates a single strategy arm pull with every execution. Returns {0,1}.
 type Arm func() float64
 
+// Scenario returns the arms in effect at the given 0 indexed trial. Scenarios
+// simulate reward distributions which change over time.
+type Scenario func(trial int) []Arm
+
+// Stationary returns a scenario where arms never change.
+func Stationary(arms []Arm) Scenario {
+	return func(trial int) []Arm {
+		return arms
+	}
+}
+
+// ChangePoint returns a scenario which switches from `before` to `after` arms
+// at trial `at`, e.g. when click-through rates drift with seasonality.
+func ChangePoint(at int, before, after []Arm) Scenario {
+	return func(trial int) []Arm {
+		if trial < at {
+			return before
+		}
+
+		return after
+	}
+}
+
 // MonteCarlo runs a monte carlo experiment with the given strategy and arms.
 func MonteCarlo(sims, trials int, arms []Arm, b Strategy) (Simulation, error) {
+	return MonteCarloScenario(sims, trials, Stationary(arms), b)
+}
+
+// MonteCarloScenario runs a monte carlo experiment with the given strategy
+// and scenario.
+func MonteCarloScenario(sims, trials int, scenario Scenario, b Strategy) (Simulation, error) {
 	s := Simulation{
 		Sims:       sims,
 		Trials:     trials,
@@ -33,7 +62,7 @@ func MonteCarlo(sims, trials int, arms []Arm, b Strategy) (Simulation, error) {
 
 		for trial := 0; trial < trials; trial++ {
 			selected := b.SelectArm()
-			reward := arms[selected-1]()
+			reward := scenario(trial)[selected-1]()
 			b.Update(selected, reward)
 
 			// record this trial into column i
@@ -0,0 +1,206 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+	"sort"
+)
+
+// SlateStrategy selects several distinct arms at once, e.g. for modules
+// placed on a page. Arms are returned in order of position, starting at the
+// top. Rewards of a slate are given in the same order. SlateProbabilities
+// returns the probability that each arm is part of the next slate of k arms,
+// which is the propensity of slates.
+type SlateStrategy interface {
+	Strategy
+	SelectArms(k int) []int
+	UpdateSlate(arms []int, rewards []float64)
+	SlateProbabilities(k int) []float64
+}
+
+// SelectArms returns the 1 indexed arms with the k highest samples from their
+// posteriors. This is top-k thompson sampling.
+func (t *thompson) SelectArms(k int) []int {
+	thetas := make([]float64, t.arms)
+	for i := 0; i < t.arms; i++ {
+		thetas[i] = t.sample(i)
+	}
+
+	arms := topK(thetas, k, t.rand.Perm(t.arms))
+	for _, arm := range arms {
+		t.counts[arm-1]++
+	}
+
+	return arms
+}
+
+// UpdateSlate updates every arm of the slate with its reward. Positions are
+// ignored.
+func (t *thompson) UpdateSlate(arms []int, rewards []float64) {
+	for i, arm := range arms {
+		t.Update(arm, rewards[i])
+	}
+}
+
+// SlateProbabilities estimates the probability that each arm draws one of the
+// k highest samples. Estimates are cached until the counters change.
+func (t *thompson) SlateProbabilities(k int) []float64 {
+	return t.slates.get(&t.Counters, k, func() []float64 {
+		return estimateInclusions(t.arms, k, t.sample)
+	})
+}
+
+// NewCascadeUCB constructs a CascadeUCB1 strategy (Kveton et al., 2015). The
+// user is assumed to scan the slate from the top and to click on the first
+// attractive arm. Arms below the click are not examined and not updated.
+func NewCascadeUCB(arms int) Strategy {
+	return &cascadeUCB{
+		Counters: NewCounters(arms),
+	}
+}
+
+// cascadeUCB estimates the attraction probability of each arm. In contrast to
+// other strategies, counts are the number of times an arm was examined, and
+// are incremented with feedback rather than with selection.
+type cascadeUCB struct {
+	Counters
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (c *cascadeUCB) SelectArm() int {
+	return c.SelectArms(1)[0]
+}
+
+// SelectArms returns the 1 indexed arms with the k highest upper confidence
+// bounds on their attraction probability.
+func (c *cascadeUCB) SelectArms(k int) []int {
+	return topK(c.bounds(), k, c.rand.Perm(c.arms))
+}
+
+// pull is a NOP. Arms are counted when they are examined.
+func (c *cascadeUCB) pull(arm int) {}
+
+// Update the 1 indexed arm, which was examined.
+func (c *cascadeUCB) Update(arm int, reward float64) {
+	c.Lock()
+	c.counts[arm-1]++
+	c.Unlock()
+
+	c.Counters.Update(arm, reward)
+}
+
+// UpdateSlate updates all arms up to and including the first one with a
+// reward. These are the arms the user examined.
+func (c *cascadeUCB) UpdateSlate(arms []int, rewards []float64) {
+	for i, arm := range arms {
+		c.Update(arm, rewards[i])
+		if rewards[i] > 0 {
+			return
+		}
+	}
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (c *cascadeUCB) Probabilities() []float64 {
+	_, imax := bmath.Max(c.bounds())
+	return uniformOver(imax, c.arms)
+}
+
+// SlateProbabilities returns the probability that each arm is among the k
+// arms with the highest bounds.
+func (c *cascadeUCB) SlateProbabilities(k int) []float64 {
+	return topKProbabilities(c.bounds(), k)
+}
+
+// bounds returns the upper confidence bound of each arm. Unexamined arms
+// have an infinite bound.
+func (c *cascadeUCB) bounds() []float64 {
+	total := 1.0
+	for _, count := range c.counts {
+		total += float64(count)
+	}
+
+	ucbValues := make([]float64, c.arms)
+	for i, count := range c.counts {
+		if count == 0 {
+			ucbValues[i] = math.Inf(1)
+			continue
+		}
+
+		bonus := math.Sqrt((1.5 * math.Log(total)) / float64(count))
+		ucbValues[i] = math.Min(c.values[i]+bonus, 1)
+	}
+
+	return ucbValues
+}
+
+// String returns information on this strategy
+func (c *cascadeUCB) String() string {
+	return fmt.Sprintf("CascadeUCB")
+}
+
+// topK returns the 1 indexed arms with the k highest values, highest first.
+// `order` is a permutation of 0 indexed arms. Ties keep this order, so a
+// random permutation breaks ties randomly.
+func topK(values []float64, k int, order []int) []int {
+	sort.Stable(byValue{arms: order, values: values})
+
+	if k > len(order) {
+		k = len(order)
+	}
+
+	selected := make([]int, k)
+	for i := range selected {
+		selected[i] = order[i] + 1
+	}
+
+	return selected
+}
+
+// topKProbabilities returns the probability that each 0 indexed arm is among
+// the k highest values, if ties are broken uniformly at random.
+func topKProbabilities(values []float64, k int) []float64 {
+	if k > len(values) {
+		k = len(values)
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+	kth := sorted[k-1]
+
+	above, tied := 0, 0
+	for _, v := range values {
+		switch {
+		case v > kth:
+			above++
+		case v == kth:
+			tied++
+		}
+	}
+
+	ps := make([]float64, len(values))
+	for i, v := range values {
+		switch {
+		case v > kth:
+			ps[i] = 1
+		case v == kth:
+			ps[i] = float64(k-above) / float64(tied)
+		}
+	}
+
+	return ps
+}
+
+// byValue sorts 0 indexed arms by descending value.
+type byValue struct {
+	arms   []int
+	values []float64
+}
+
+func (b byValue) Len() int           { return len(b.arms) }
+func (b byValue) Less(i, j int) bool { return b.values[b.arms[i]] > b.values[b.arms[j]] }
+func (b byValue) Swap(i, j int)      { b.arms[i], b.arms[j] = b.arms[j], b.arms[i] }
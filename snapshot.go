@@ -5,41 +5,173 @@ package bandit
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// SnapshotVersion is the version of snapshots produced by bandit-job.
+const SnapshotVersion = 2
+
+// Snapshot is a version 2 snapshot. It carries the sufficient statistics of
+// each arm along with metadata, and is encoded as json:
+//
+//     {
+//       "version": 2,
+//       "experiment": "shape-20130822",
+//       "generated": "2013-09-13T10:00:00Z",
+//       "job-version": "1.2",
+//       "arms": [
+//         {"tag": "shape-20130822:1", "count": 10, "sum": 1, "sum-squares": 1},
+//         {"tag": "shape-20130822:3", "count": 20, "sum": 10, "sum-squares": 10}
+//       ]
+//     }
+type Snapshot struct {
+	Version    int           `json:"version"`
+	Experiment string        `json:"experiment"`
+	Generated  time.Time     `json:"generated"`
+	JobVersion string        `json:"job-version"`
+	Arms       []ArmSnapshot `json:"arms"`
+}
+
+// ArmSnapshot holds the number of pulls of an arm, the sum of its rewards
+// and the sum of its squared rewards. Arms rewarded with features also hold
+// the regression of their rewards on the features, for contextual strategies.
+type ArmSnapshot struct {
+	Tag        string      `json:"tag"`
+	Count      int         `json:"count"`
+	Sum        float64     `json:"sum"`
+	SumSquares float64     `json:"sum-squares"`
+	Regression *Regression `json:"regression,omitempty"`
+}
+
+// Regression holds the sufficient statistics of a linear regression of
+// rewards on features x, which are extended by a constant bias term 1: the
+// sums of x·xᵀ and of reward·x over all rewards.
+type Regression struct {
+	Gram    [][]float64 `json:"gram"`
+	Moments []float64   `json:"moments"`
+}
+
+// dimensions returns the number of features of the regression, excluding the
+// bias term, or -1 if the statistics are malformed.
+func (r *Regression) dimensions() int {
+	if len(r.Moments) == 0 || len(r.Gram) != len(r.Moments) {
+		return -1
+	}
+
+	for _, row := range r.Gram {
+		if len(row) != len(r.Moments) {
+			return -1
+		}
+	}
+
+	return len(r.Moments) - 1
+}
+
+// Counters returns counters with the statistics of the snapshot's arms.
+func (s Snapshot) Counters() *Counters {
+	c := NewCounters(len(s.Arms))
+	c.tags = make([]string, len(s.Arms))
+	c.generated = s.Generated
+	for i, arm := range s.Arms {
+		if arm.Regression != nil {
+			if c.regressions == nil {
+				c.regressions = make([]*Regression, len(s.Arms))
+			}
+
+			c.regressions[i] = arm.Regression
+		}
+
+		c.tags[i] = arm.Tag
+		c.counts[i] = arm.Count
+		if arm.Count > 0 {
+			c.values[i] = arm.Sum / float64(arm.Count)
+			c.squares[i] = arm.SumSquares / float64(arm.Count)
+		}
+	}
+
+	return &c
+}
+
+// DecodeSnapshot reads a version 2 snapshot.
+func DecodeSnapshot(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return Snapshot{}, fmt.Errorf("could not decode snapshot: %s", err.Error())
+	}
+
+	if s.Version != SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	for _, arm := range s.Arms {
+		if arm.Tag == "" || arm.Count < 0 {
+			return Snapshot{}, fmt.Errorf("arms need a tag and a count >= 0")
+		}
+
+		if arm.Regression != nil && arm.Regression.dimensions() < 0 {
+			return Snapshot{}, fmt.Errorf("%s: malformed regression", arm.Tag)
+		}
+	}
+
+	return s, nil
+}
+
 // GetSnapshot returns Counters given a snapshot filename.
-func GetSnapshot(o Opener) (Counters, error) {
+func GetSnapshot(o Opener) (*Counters, error) {
 	reader, err := o.Open()
 	if err != nil {
-		return Counters{}, fmt.Errorf("could not open: %s", err.Error())
+		return &Counters{}, fmt.Errorf("could not open: %s", err.Error())
 	}
 
 	counters, err := ParseSnapshot(reader)
 	if err != nil {
-		return Counters{}, fmt.Errorf("could not parse snapshot: %s", err.Error())
+		return &Counters{}, fmt.Errorf("could not parse snapshot: %s", err.Error())
 	}
 
 	return counters, nil
 }
 
-// ParseSnapshot reads in a snapshot file. Snapshot files contain a single
+// ParseSnapshot reads in a snapshot file of any version. Version 2 snapshots
+// are json encoded, see Snapshot. Version 1 snapshot files contain a single
 // line experiment snapshot, for example:
 //
 // 2	0.1	0.5
 //
 // Tokens are separated by whitespace. The given example encodes an experiment
 // with two variations. First is the number of variations. This is followed by
-// rewards (mean reward for each arm).
-func ParseSnapshot(s io.Reader) (Counters, error) {
+// rewards (mean reward for each arm). Rewards may be preceded by the tag of
+// their variation, so that arms are mapped by tag rather than by position,
+// and by the number of pulls of the arm:
+//
+// 2	shape-20130822:1	0.1	shape-20130822:3	0.5
+// 2	shape-20130822:1	10	0.1	shape-20130822:3	20	0.5
+func ParseSnapshot(s io.Reader) (*Counters, error) {
+	snapshot, err := ioutil.ReadAll(s)
+	if err != nil {
+		return &Counters{}, fmt.Errorf("could not read snapshot: %s", err.Error())
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(snapshot), []byte("{")) {
+		v2, err := DecodeSnapshot(bytes.NewReader(snapshot))
+		if err != nil {
+			return &Counters{}, err
+		}
+
+		return v2.Counters(), nil
+	}
+
 	lines := 0
 	var line string
-	for scanner := bufio.NewScanner(s); scanner.Scan(); lines++ {
+	for scanner := bufio.NewScanner(bytes.NewReader(snapshot)); scanner.Scan(); lines++ {
 		if lines > 1 {
-			return Counters{}, fmt.Errorf("> 1 line in snapshot")
+			return &Counters{}, fmt.Errorf("> 1 line in snapshot")
 		}
 
 		line = scanner.Text()
@@ -48,18 +180,42 @@ func ParseSnapshot(s io.Reader) (Counters, error) {
 	fields := strings.Fields(line)
 	arms, err := strconv.ParseInt(fields[0], 10, 16)
 	if err != nil {
-		return Counters{}, fmt.Errorf("arms not an int: %s", err.Error())
+		return &Counters{}, fmt.Errorf("arms not an int: %s", err.Error())
 	}
 
-	if int(arms) != len(fields)-1 {
-		return Counters{}, fmt.Errorf("more fields than arms")
+	var tags []string
+	var counts []int
+	values := fields[1:]
+	switch len(values) {
+	case int(arms) * 2:
+		values = nil
+		for i := 1; i < len(fields); i += 2 {
+			tags = append(tags, fields[i])
+			values = append(values, fields[i+1])
+		}
+	case int(arms) * 3:
+		values = nil
+		for i := 1; i < len(fields); i += 3 {
+			count, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return &Counters{}, fmt.Errorf("counts malformed: %s", err.Error())
+			}
+
+			tags = append(tags, fields[i])
+			counts = append(counts, count)
+			values = append(values, fields[i+2])
+		}
+	}
+
+	if int(arms) != len(values) {
+		return &Counters{}, fmt.Errorf("more fields than arms")
 	}
 
 	var rewards []float64
-	for _, str := range fields[1:] {
+	for _, str := range values {
 		reward, err := strconv.ParseFloat(str, 64)
 		if err != nil {
-			return Counters{}, fmt.Errorf("rewards malformed: %s", err.Error())
+			return &Counters{}, fmt.Errorf("rewards malformed: %s", err.Error())
 		}
 
 		rewards = append(rewards, reward)
@@ -67,6 +223,10 @@ func ParseSnapshot(s io.Reader) (Counters, error) {
 
 	c := NewCounters(int(arms))
 	c.values = rewards
+	c.tags = tags
+	if counts != nil {
+		c.counts = counts
+	}
 
-	return c, nil
+	return &c, nil
 }
@@ -1,6 +1,7 @@
 package bandit
 
 import (
+	"math"
 	"strings"
 	"testing"
 )
@@ -28,3 +29,84 @@ func TestParseSnapshot(t *testing.T) {
 		t.Fatalf("expected arms to be %f but got %f", expectedReward, got)
 	}
 }
+
+func TestParseTaggedSnapshot(t *testing.T) {
+	input := strings.NewReader("2	shape:1	0.120000	shape:3	0.300000")
+
+	s, err := ParseSnapshot(input)
+	if err != nil {
+		t.Fatalf("could not parse snapshot file: %s", err)
+	}
+
+	expectedArms := 2
+	if got := s.arms; got != expectedArms {
+		t.Fatalf("expected %d arms but got %d", expectedArms, got)
+	}
+
+	expectedTag := "shape:3"
+	if got := s.tags[1]; got != expectedTag {
+		t.Fatalf("expected tag %s but got %s", expectedTag, got)
+	}
+
+	expectedReward := float64(0.3)
+	if got := s.values[1]; got != expectedReward {
+		t.Fatalf("expected arms to be %f but got %f", expectedReward, got)
+	}
+}
+
+func TestParseCountedSnapshot(t *testing.T) {
+	input := strings.NewReader("2	shape:1	10	0.120000	shape:3	20	0.300000")
+
+	s, err := ParseSnapshot(input)
+	if err != nil {
+		t.Fatalf("could not parse snapshot file: %s", err)
+	}
+
+	expectedCount := 20
+	if got := s.counts[1]; got != expectedCount {
+		t.Fatalf("expected %d pulls but got %d", expectedCount, got)
+	}
+
+	expectedReward := float64(0.3)
+	if got := s.values[1]; got != expectedReward {
+		t.Fatalf("expected arms to be %f but got %f", expectedReward, got)
+	}
+}
+
+func TestParseSnapshotV2(t *testing.T) {
+	snapshot := `{
+		"version": 2,
+		"experiment": "shape-20130822",
+		"generated": "2013-09-13T10:00:00Z",
+		"job-version": "1.2",
+		"arms": [
+			{"tag": "shape-20130822:1", "count": 10, "sum": 2, "sum-squares": 2},
+			{"tag": "shape-20130822:3", "count": 0, "sum": 0, "sum-squares": 0}
+		]
+	}`
+
+	c, err := ParseSnapshot(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	if got := c.counts; got[0] != 10 || got[1] != 0 {
+		t.Fatalf("unexpected counts %v", got)
+	}
+
+	if got := c.values[0]; got != 0.2 {
+		t.Fatalf("expected mean reward of 0.2, got %f", got)
+	}
+
+	if got := c.variance(0); math.Abs(got-0.16) > 1e-9 {
+		t.Fatalf("expected variance of 0.16, got %f", got)
+	}
+
+	if got := c.tags[1]; got != "shape-20130822:3" {
+		t.Fatalf("unexpected tag %s", got)
+	}
+
+	if _, err := ParseSnapshot(strings.NewReader(`{"version": 3, "arms": []}`)); err == nil {
+		t.Fatalf("expected unknown versions to be rejected")
+	}
+}
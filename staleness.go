@@ -0,0 +1,200 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Fallback is the selection policy of experiments with a stale snapshot.
+type Fallback string
+
+const (
+	// FallbackPreferred serves the preferred variation to everyone.
+	FallbackPreferred Fallback = "preferred"
+
+	// FallbackUniform selects variations uniformly at random.
+	FallbackUniform Fallback = "uniform"
+
+	// FallbackLastKnown keeps selecting with the last known counters.
+	FallbackLastKnown Fallback = "last-known"
+)
+
+// Staleness is the policy of delayed strategies towards stale snapshots. A
+// snapshot is stale once it is older than MaxAge seconds, or after
+// MaxFailures consecutive failed fetches. Stale experiments select according
+// to Fallback, which is the preferred variation by default. The zero policy
+// never considers snapshots stale.
+type Staleness struct {
+	MaxAge      int      `json:"max-staleness-seconds"`
+	MaxFailures int      `json:"max-snapshot-failures"`
+	Fallback    Fallback `json:"fallback"`
+}
+
+// enabled returns true if the policy is configured.
+func (s Staleness) enabled() bool {
+	return s.MaxAge > 0 || s.MaxFailures > 0
+}
+
+// check returns an error if the policy is invalid.
+func (s Staleness) check() error {
+	if s.MaxAge < 0 || s.MaxFailures < 0 {
+		return fmt.Errorf("max-staleness-seconds and max-snapshot-failures must not be negative")
+	}
+
+	switch s.Fallback {
+	case "", FallbackPreferred, FallbackUniform, FallbackLastKnown:
+	default:
+		return fmt.Errorf("'%s' unknown fallback", s.Fallback)
+	}
+
+	if s.Fallback != "" && !s.enabled() {
+		return fmt.Errorf("fallback needs max-staleness-seconds or max-snapshot-failures")
+	}
+
+	return nil
+}
+
+// fallback returns the configured fallback, which defaults to the preferred
+// variation.
+func (s Staleness) fallback() Fallback {
+	if s.Fallback == "" {
+		return FallbackPreferred
+	}
+
+	return s.Fallback
+}
+
+// Freshness is the state of the snapshot of a delayed strategy. Snapshots
+// without a generation time, e.g. version 1 snapshots, age from the time they
+// were fetched.
+type Freshness struct {
+	Generated time.Time `json:"generated"`
+	Fetched   time.Time `json:"fetched"`  // last successful fetch
+	Failures  int       `json:"failures"` // consecutive failed fetches
+	Age       float64   `json:"age-seconds"`
+	Stale     bool      `json:"stale"`
+	Fallback  Fallback  `json:"fallback,omitempty"` // in effect while stale
+}
+
+// Freshness returns the snapshot state of the experiment and each of its
+// segments with a delayed strategy, keyed by name. Experiments without
+// snapshots are missing.
+func (e *Experiment) Freshness() map[string]Freshness {
+	now := time.Now()
+	freshness := make(map[string]Freshness)
+	for _, x := range e.experiments() {
+		x.mu.RLock()
+		if d, ok := x.Strategy.(*delayedStrategy); ok {
+			freshness[x.Name] = d.freshness(x.config.Staleness, now)
+		}
+		x.mu.RUnlock()
+	}
+
+	return freshness
+}
+
+// fallback returns the selection of experiments whose snapshot is stale,
+// according to the fallback policy. Experiments which keep the last known
+// counters do not fall back.
+func (e *Experiment) fallback() (Selection, bool) {
+	d, ok := e.Strategy.(*delayedStrategy)
+	if !ok {
+		return Selection{}, false
+	}
+
+	f := d.freshness(e.config.Staleness, time.Now())
+	if !f.Stale {
+		return Selection{}, false
+	}
+
+	switch f.Fallback {
+	case FallbackUniform:
+		arms := len(e.Variations)
+		return Selection{
+			Variation:  e.variation(d.intn(arms) + 1),
+			Propensity: 1 / float64(arms),
+		}, true
+	case FallbackLastKnown:
+		return Selection{}, false
+	}
+
+	return Selection{Variation: e.variation(e.PreferredOrdinal), Propensity: 1}, true
+}
+
+// randomized strategies draw random numbers from their own source, which is
+// seeded with the experiment.
+type randomized interface {
+	random() *rand.Rand
+}
+
+// intn draws from [0, n) with the random source of the wrapped strategy, so
+// that seeded experiments fall back reproducibly.
+func (b *delayedStrategy) intn(n int) int {
+	if s, ok := b.strategy.(randomized); ok {
+		return s.random().Intn(n)
+	}
+
+	return rand.Intn(n)
+}
+
+// refresh initializes the strategy with fetched snapshot counters.
+func (b *delayedStrategy) refresh(c *Counters) {
+	if err := b.Init(c); err != nil {
+		log.Printf("Error: could not init snapshot: %s", err.Error())
+		b.failed()
+		return
+	}
+
+	b.succeeded(c)
+}
+
+// succeeded records the successful fetch of snapshot c.
+func (b *delayedStrategy) succeeded(c *Counters) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.fetched = time.Now()
+	b.generated = c.generated
+	b.failures = 0
+}
+
+// failed records a failed fetch.
+func (b *delayedStrategy) failed() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures++
+}
+
+// freshness returns the state of the snapshot at time `now` under policy s.
+func (b *delayedStrategy) freshness(s Staleness, now time.Time) Freshness {
+	b.Lock()
+	defer b.Unlock()
+
+	since := b.generated
+	if since.IsZero() {
+		since = b.fetched
+	}
+
+	f := Freshness{
+		Generated: b.generated,
+		Fetched:   b.fetched,
+		Failures:  b.failures,
+		Age:       now.Sub(since).Seconds(),
+	}
+
+	maxAge := time.Duration(s.MaxAge) * time.Second
+	f.Stale = (s.MaxAge > 0 && now.Sub(since) > maxAge) ||
+		(s.MaxFailures > 0 && b.failures >= s.MaxFailures)
+
+	if f.Stale {
+		f.Fallback = s.fallback()
+	}
+
+	return f
+}
@@ -0,0 +1,246 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleness(t *testing.T) {
+	file, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatalf("could not create snapshot: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{"version": 2, "experiment": "shape", "generated": "2013-09-13T10:00:00Z", "arms": [
+		{"tag": "shape:1", "count": 10, "sum": 9},
+		{"tag": "shape:2", "count": 10, "sum": 1}
+	]}`)
+	file.Close()
+
+	experiment := func(staleness string) *Experiment {
+		config := fmt.Sprintf(`[{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"snapshot": "%s",
+			"snapshot-poll-seconds": 3600,
+			%s
+			"preferred": 2,
+			"variations": [
+				{"url": "circle", "ordinal": 1},
+				{"url": "square", "ordinal": 2}
+			]
+		}]`, file.Name(), staleness)
+
+		e, err := NewExperiment(stringOpener(config), "shape")
+		if err != nil {
+			t.Fatalf("could not make experiment: %s", err.Error())
+		}
+
+		return e
+	}
+
+	// snapshot from 2013 is older than an hour
+	preferred := experiment(`"max-staleness-seconds": 3600,`)
+	defer stopStrategy(preferred.Strategy)
+
+	f := preferred.Freshness()["shape"]
+	if !f.Stale || f.Fallback != FallbackPreferred {
+		t.Fatalf("expected stale snapshot with preferred fallback, got %v", f)
+	}
+
+	for i := 0; i < 100; i++ {
+		s, err := preferred.SelectRequest(Request{UID: fmt.Sprintf("%d", i)})
+		if err != nil {
+			t.Fatalf("could not select variation: %s", err.Error())
+		}
+
+		if s.Ordinal != 2 || s.Propensity != 1 {
+			t.Fatalf("expected stale experiment to serve preferred variation, got %d", s.Ordinal)
+		}
+	}
+
+	uniform := experiment(`"max-staleness-seconds": 3600, "fallback": "uniform",`)
+	defer stopStrategy(uniform.Strategy)
+
+	s, err := uniform.SelectRequest(Request{})
+	if err != nil {
+		t.Fatalf("could not select variation: %s", err.Error())
+	}
+
+	if s.Propensity != 0.5 {
+		t.Fatalf("expected uniform propensity 0.5, got %f", s.Propensity)
+	}
+
+	lastKnown := experiment(`"max-staleness-seconds": 3600, "fallback": "last-known",`)
+	defer stopStrategy(lastKnown.Strategy)
+
+	if f := lastKnown.Freshness()["shape"]; !f.Stale {
+		t.Fatalf("expected stale snapshot, got %v", f)
+	}
+
+	if _, ok := lastKnown.fixed(); ok {
+		t.Fatalf("expected last known counters to keep exploring")
+	}
+}
+
+func TestStalenessInitial(t *testing.T) {
+	file, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatalf("could not create snapshot: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{"version": 2, "experiment": "shape", "arms": [
+		{"tag": "shape:1", "count": 10, "sum": 9},
+		{"tag": "shape:2", "count": 10, "sum": 1}
+	]}`)
+	file.Close()
+
+	experiment := func(staleness string) *Experiment {
+		config := fmt.Sprintf(`[{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			"seed": 7,
+			"snapshot": "%s",
+			"snapshot-poll-seconds": 3600,
+			%s
+			"preferred": 2,
+			"variations": [
+				{"url": "circle", "ordinal": 1},
+				{"url": "square", "ordinal": 2}
+			]
+		}]`, file.Name(), staleness)
+
+		e, err := NewExperiment(stringOpener(config), "shape")
+		if err != nil {
+			t.Fatalf("could not make experiment: %s", err.Error())
+		}
+
+		return e
+	}
+
+	// initialized from the first fetch, long before the first tick
+	e := experiment(`"max-staleness-seconds": 3600,`)
+	defer stopStrategy(e.Strategy)
+
+	if f := e.Freshness()["shape"]; f.Stale || time.Since(f.Fetched) > time.Minute {
+		t.Fatalf("expected fresh snapshot, got %v", f)
+	}
+
+	if got := e.Strategy.(*delayedStrategy).Snapshot(); got.counts[0] != 10 || got.values[0] != 0.9 {
+		t.Fatalf("expected counters from the initial snapshot, got %v", got)
+	}
+
+	// seeded experiments fall back reproducibly
+	fallback := func() []int {
+		e := experiment(`"max-snapshot-failures": 1, "fallback": "uniform",`)
+		defer stopStrategy(e.Strategy)
+
+		e.Strategy.(*delayedStrategy).failed()
+		ordinals := make([]int, 100)
+		for i := range ordinals {
+			s, err := e.SelectRequest(Request{})
+			if err != nil {
+				t.Fatalf("could not select variation: %s", err.Error())
+			}
+
+			ordinals[i] = s.Ordinal
+		}
+
+		return ordinals
+	}
+
+	a, b := fallback(), fallback()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("seeded fallbacks differ at selection %d", i)
+		}
+	}
+}
+
+func TestStalenessFailures(t *testing.T) {
+	file, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatalf("could not create snapshot: %s", err.Error())
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString("2	shape:1	0.9	shape:2	0.1")
+	file.Close()
+
+	config := fmt.Sprintf(`[{
+		"experiment_name": "shape",
+		"strategy": "epsilonGreedy",
+		"parameters": [0.1],
+		"snapshot": "%s",
+		"snapshot-poll-seconds": 3600,
+		"max-snapshot-failures": 2,
+		"preferred": 1,
+		"variations": [
+			{"url": "circle", "ordinal": 1},
+			{"url": "square", "ordinal": 2}
+		]
+	}]`, file.Name())
+
+	e, err := NewExperiment(stringOpener(config), "shape")
+	if err != nil {
+		t.Fatalf("could not make experiment: %s", err.Error())
+	}
+
+	defer stopStrategy(e.Strategy)
+
+	d := e.Strategy.(*delayedStrategy)
+	d.failed()
+	if f := e.Freshness()["shape"]; f.Stale || f.Failures != 1 {
+		t.Fatalf("expected fresh snapshot after 1 failure, got %v", f)
+	}
+
+	d.failed()
+	if f := e.Freshness()["shape"]; !f.Stale {
+		t.Fatalf("expected stale snapshot after 2 failures, got %v", f)
+	}
+
+	c, err := ParseSnapshot(strings.NewReader("2	shape:1	0.9	shape:2	0.1"))
+	if err != nil {
+		t.Fatalf("could not parse snapshot: %s", err.Error())
+	}
+
+	d.refresh(c)
+	f := e.Freshness()["shape"]
+	if f.Stale || f.Failures != 0 || time.Since(f.Fetched) > time.Minute {
+		t.Fatalf("expected fresh snapshot after refresh, got %v", f)
+	}
+}
+
+func TestStalenessConfig(t *testing.T) {
+	invalid := []string{
+		`"fallback": "uniform"`,
+		`"max-staleness-seconds": 60, "fallback": "random", "snapshot": "shape.json", "snapshot-poll-seconds": 60`,
+		`"max-snapshot-failures": -1, "snapshot": "shape.json", "snapshot-poll-seconds": 60`,
+	}
+
+	for _, staleness := range invalid {
+		config := fmt.Sprintf(`[{
+			"experiment_name": "shape",
+			"strategy": "epsilonGreedy",
+			"parameters": [0.1],
+			%s,
+			"preferred": 1,
+			"variations": [{"url": "circle", "ordinal": 1}]
+		}]`, staleness)
+
+		if _, err := parseExperiments([]byte(config)); err == nil {
+			t.Fatalf("expected invalid staleness policy: %s", staleness)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2013 SoundCloud, Rany Keddo. All rights reserved.  Use of this
+// source code is governed by a license that can be found in the LICENSE file.
+
+package bandit
+
+import (
+	"fmt"
+	bmath "github.com/purzelrakete/bandit/math"
+	"math"
+)
+
+// NewKLUCB returns a KL-UCB strategy (Garivier & Cappé, 2011) for Bernoulli
+// rewards. The exploration bound is log(t) + c·log(log(t)).
+func NewKLUCB(arms int, c float64) (Strategy, error) {
+	if !(c >= 0) {
+		return &klUCB{}, fmt.Errorf("c not in [0, ∞)")
+	}
+
+	return &klUCB{
+		Counters: NewCounters(arms),
+		c:        c,
+	}, nil
+}
+
+// klUCB selects the arm with the highest upper confidence bound under the
+// Bernoulli Kullback-Leibler divergence. Bounds are much tighter than UCB1's
+// for rewards close to 0 or 1, e.g. low conversion rates.
+type klUCB struct {
+	Counters
+	c float64 // weight of the log(log(t)) exploration term
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (k *klUCB) SelectArm() int {
+	imax := ucbCandidates(&k.Counters, k.bound)
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[k.rand.Intn(len(imax))]
+
+	k.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (k *klUCB) Probabilities() []float64 {
+	return uniformOver(ucbCandidates(&k.Counters, k.bound), k.arms)
+}
+
+// bound is the upper confidence bound of the 0 indexed arm.
+func (k *klUCB) bound(arm int, total float64) float64 {
+	n, p := float64(k.counts[arm]), k.values[arm]
+	bound := math.Log(total)
+	if total > math.E {
+		bound += k.c * math.Log(math.Log(total))
+	}
+
+	return bmath.Bisect(func(q float64) float64 {
+		return n*bmath.KLBernoulli(p, q) - bound
+	}, p, 1, 1e-6)
+}
+
+// rewardRange returns [0, 1], rewards are Bernoulli.
+func (k *klUCB) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// String returns information on this strategy
+func (k *klUCB) String() string {
+	return fmt.Sprintf("KL-UCB(c=%.2f)", k.c)
+}
+
+// NewUCB1Tuned returns a UCB1-Tuned strategy (Auer et al., 2002).
+func NewUCB1Tuned(arms int) Strategy {
+	return &uCB1Tuned{
+		Counters: NewCounters(arms),
+	}
+}
+
+// uCB1Tuned scales the UCB1 bonus with an upper bound on each arm's reward
+// variance.
+type uCB1Tuned struct {
+	Counters
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (u *uCB1Tuned) SelectArm() int {
+	imax := ucbCandidates(&u.Counters, u.bound)
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[u.rand.Intn(len(imax))]
+
+	u.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (u *uCB1Tuned) Probabilities() []float64 {
+	return uniformOver(ucbCandidates(&u.Counters, u.bound), u.arms)
+}
+
+// bound is the upper confidence bound of the 0 indexed arm.
+func (u *uCB1Tuned) bound(arm int, total float64) float64 {
+	n := float64(u.counts[arm])
+	v := u.variance(arm) + math.Sqrt(2*math.Log(total)/n)
+	return u.values[arm] + math.Sqrt(math.Log(total)/n*math.Min(0.25, v))
+}
+
+// rewardRange returns [0, 1], the variance bound assumes rewards in [0, 1].
+func (u *uCB1Tuned) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// String returns information on this strategy
+func (u *uCB1Tuned) String() string {
+	return fmt.Sprintf("UCB1-Tuned")
+}
+
+// NewUCBV returns a UCB-V strategy (Audibert et al., 2009) for rewards in
+// [0, 1]. ζ controls exploration; ζ > 1 is required for logarithmic regret.
+func NewUCBV(arms int, ζ float64) (Strategy, error) {
+	if !(ζ > 0) {
+		return &uCBV{}, fmt.Errorf("ζ not in (0, ∞)")
+	}
+
+	return &uCBV{
+		Counters: NewCounters(arms),
+		zeta:     ζ,
+	}, nil
+}
+
+// uCBV uses an empirical Bernstein bound, based on each arm's reward
+// variance.
+type uCBV struct {
+	Counters
+	zeta float64 // exploration
+}
+
+// SelectArm returns 1 indexed arm to be tried next.
+func (u *uCBV) SelectArm() int {
+	imax := ucbCandidates(&u.Counters, u.bound)
+	// best arm. randomly pick because there may be equally best arms.
+	arm := imax[u.rand.Intn(len(imax))]
+
+	u.counts[arm]++
+	return arm + 1
+}
+
+// Probabilities returns the probability of selecting each arm.
+func (u *uCBV) Probabilities() []float64 {
+	return uniformOver(ucbCandidates(&u.Counters, u.bound), u.arms)
+}
+
+// bound is the upper confidence bound of the 0 indexed arm.
+func (u *uCBV) bound(arm int, total float64) float64 {
+	n := float64(u.counts[arm])
+	e := u.zeta * math.Log(total)
+	return u.values[arm] + math.Sqrt(2*u.variance(arm)*e/n) + 3*e/n
+}
+
+// rewardRange returns [0, 1], the Bernstein bound assumes rewards in [0, 1].
+func (u *uCBV) rewardRange() (float64, float64) {
+	return 0, 1
+}
+
+// String returns information on this strategy
+func (u *uCBV) String() string {
+	return fmt.Sprintf("UCB-V(zeta=%.2f)", u.zeta)
+}
+
+// ucbCandidates returns the 0 indexed arms with the highest upper confidence
+// bound. Bounds are computed given the 0 indexed arm and the total number of
+// pulls. Untried arms are selected first.
+func ucbCandidates(c *Counters, bound func(arm int, total float64) float64) []int {
+	for i, count := range c.counts {
+		if count == 0 {
+			return []int{i}
+		}
+	}
+
+	var totalCounts int
+	for _, count := range c.counts {
+		totalCounts += count
+	}
+
+	ucbValues := make([]float64, c.arms)
+	for i := 0; i < c.arms; i++ {
+		ucbValues[i] = bound(i, float64(totalCounts))
+	}
+
+	_, imax := bmath.Max(ucbValues)
+	return imax
+}